@@ -1,12 +1,19 @@
 package generator
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
 	"log"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/yoheimuta/go-protoparser/v4/interpret/unordered"
+	"github.com/yoheimuta/go-protoparser/v4/parser"
 )
 
 const generatorWarning = "// THIS FILE WAS AUTOMATICALLY GENERATED BY https://github.com/avirillion/GoWsProtoServiceBuilder\n// DO NOT MODIFY!\n\n"
@@ -42,6 +49,35 @@ func hasServiceOption(srv *unordered.Service, name string) bool {
 	return hasOption
 }
 
+// hasServicesWithOption checks if any service is tagged with the option
+func hasServicesWithOption(pb *unordered.Proto, name string) bool {
+	for _, srv := range pb.ProtoBody.Services {
+		if hasServiceOption(srv, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// schemaFingerprint hashes the signatures of all services and methods, independent of their order
+func schemaFingerprint(pb *unordered.Proto) string {
+	var signatures []string
+	for _, srv := range pb.ProtoBody.Services {
+		kind := "service"
+		if hasServiceOption(srv, "(is_rpc)") {
+			kind = "rpc"
+		} else if hasServiceOption(srv, "(is_ssp)") {
+			kind = "ssp"
+		}
+		for _, rpc := range srv.ServiceBody.RPCs {
+			signatures = append(signatures, kind+" "+srv.ServiceName+"."+rpc.RPCName+"("+rpc.RPCRequest.MessageType+") "+rpc.RPCResponse.MessageType)
+		}
+	}
+	sort.Strings(signatures)
+	hash := sha256.Sum256([]byte(strings.Join(signatures, "\n")))
+	return hex.EncodeToString(hash[:8])
+}
+
 func firstCharToUpper(s string) string {
 	if len(s) == 0 {
 		return s
@@ -55,3 +91,33 @@ func firstCharToLower(s string) string {
 	}
 	return strings.ToLower(s[0:1]) + s[1:]
 }
+
+// rpcOptions returns the options of a method by name without parentheses, and the names in declaration order
+func rpcOptions(rpc *parser.RPC) (options map[string]string, names []string) {
+	options = make(map[string]string)
+	for _, opt := range rpc.Options {
+		name := strings.TrimSuffix(strings.TrimPrefix(opt.OptionName, "("), ")")
+		value, err := strconv.Unquote(opt.Constant)
+		if err != nil {
+			value = opt.Constant
+		}
+		if _, exists := options[name]; !exists {
+			names = append(names, name)
+		}
+		options[name] = value
+	}
+	return options, names
+}
+
+// rpcTimeout parses the (timeout) option of a method. Without the option it returns 0.
+func rpcTimeout(method string, options map[string]string) (time.Duration, error) {
+	timeout, exists := options["timeout"]
+	if !exists {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(timeout)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("rpc %s: invalid timeout '%s', expected e.g. '5s'", method, timeout)
+	}
+	return d, nil
+}
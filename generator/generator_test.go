@@ -0,0 +1,207 @@
+package generator
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	pp "github.com/yoheimuta/go-protoparser/v4"
+	"github.com/yoheimuta/go-protoparser/v4/parser"
+)
+
+// the sample protos in testdata: with rpc and push services, only rpc services, only push services
+// and services sharing method names
+var testShapes = []string{"full", "rpc", "ssp", "shared"}
+
+func parseTestProto(t *testing.T, file string) *parser.Proto {
+	t.Helper()
+	reader, err := os.Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	pbuf, err := pp.Parse(reader)
+	if err != nil {
+		t.Fatalf("failed to parse '%s': %v", file, err)
+	}
+	return pbuf
+}
+
+// generateGoModule generates the Go code of a sample proto into a module together with the messages of the
+// sample protos, and adds the tests of testdata/common and of the directory named like the shape.
+func generateGoModule(t *testing.T, shape string) string {
+	t.Helper()
+	pbuf := parseTestProto(t, filepath.Join("testdata", shape+".proto"))
+	dir := t.TempDir()
+	generators := []func() error{
+		func() error { return GenerateGoCommon(dir, "api") },
+		func() error { return GenerateGoRpcService(pbuf, dir, "api") },
+		func() error { return GenerateGoSspService(pbuf, dir, "api") },
+		func() error { return GenerateGoMetrics(dir, "api") },
+		func() error { return GenerateGoTracing(dir, "api") },
+		func() error { return GenerateGoRateLimit(dir, "api") },
+		func() error { return GenerateGoConn(pbuf, dir, "api") },
+		func() error { return GenerateGoClient(pbuf, dir, "api") },
+		func() error { return GenerateGoTestKit(dir, "api") },
+	}
+	for _, generate := range generators {
+		if err := generate(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	goMod := "module example.com/generated\n\ngo 1.22\n\nrequire google.golang.org/protobuf v1.36.8\n"
+	writeTestFile(t, filepath.Join(dir, "go.mod"), []byte(goMod))
+	copyTestFile(t, filepath.Join("testdata", "go.sum"), filepath.Join(dir, "go.sum"))
+	copyTestFile(t, filepath.Join("testdata", "messages.pb.go"), filepath.Join(dir, "api", "messages.pb.go"))
+	for _, testDir := range []string{"common", shape} {
+		files, err := filepath.Glob(filepath.Join("testdata", testDir, "*_test.go"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, file := range files {
+			copyTestFile(t, file, filepath.Join(dir, "api", filepath.Base(file)))
+		}
+	}
+	return dir
+}
+
+func copyTestFile(t *testing.T, from string, to string) {
+	t.Helper()
+	data, err := os.ReadFile(from)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, to, data)
+}
+
+func writeTestFile(t *testing.T, file string, data []byte) {
+	t.Helper()
+	if err := os.WriteFile(file, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func runGo(t *testing.T, dir string, args ...string) {
+	t.Helper()
+	cmd := exec.Command("go", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GOFLAGS=-mod=mod", "GOWORK=off")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("go %v: %v\n%s", args, err, out)
+	}
+}
+
+func TestGeneratedGoCode(t *testing.T) {
+	if testing.Short() {
+		t.Skip("compiles the generated code")
+	}
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go command not available")
+	}
+	for _, shape := range testShapes {
+		t.Run(shape, func(t *testing.T) {
+			t.Parallel()
+			dir := generateGoModule(t, shape)
+			runGo(t, dir, "vet", "./...")
+			runGo(t, dir, "test", "./...")
+		})
+	}
+}
+
+func TestGenerateTypeScript(t *testing.T) {
+	for _, shape := range testShapes {
+		file := filepath.Join("testdata", shape+".proto")
+		pbuf := parseTestProto(t, file)
+		for _, runtime := range []TsRuntime{TsRuntimeProtobufJs, TsRuntimeTsProto, TsRuntimeProtobufEs} {
+			dir := t.TempDir()
+			if err := GenerateTypeScriptFile(pbuf, "testdata", file, dir, runtime); err != nil {
+				t.Fatal(err)
+			}
+			if err := GenerateTypeScriptServer(pbuf, "testdata", file, dir, runtime); err != nil {
+				t.Fatal(err)
+			}
+			if err := GenerateTypeScriptReactHooks(pbuf, "testdata", file, dir, runtime); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+// TestGeneratedTypeScriptCode runs the tests of testdata/ts/<shape> against the TypeScript client and server
+// generated for protobufjs. Node.js runs them without a compiler, which needs version 22.7 or later.
+func TestGeneratedTypeScriptCode(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs the generated code")
+	}
+	if err := exec.Command("node", "--experimental-transform-types", "--no-warnings", "--eval", "").Run(); err != nil {
+		t.Skip("node with TypeScript support not available")
+	}
+	for _, shape := range testShapes {
+		tests, err := filepath.Glob(filepath.Join("testdata", "ts", shape, "*.test.ts"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(tests) == 0 {
+			continue
+		}
+		t.Run(shape, func(t *testing.T) {
+			t.Parallel()
+			file := filepath.Join("testdata", shape+".proto")
+			pbuf := parseTestProto(t, file)
+			dir := t.TempDir()
+			if err := GenerateTypeScriptFile(pbuf, "testdata", file, dir, TsRuntimeProtobufJs); err != nil {
+				t.Fatal(err)
+			}
+			if err := GenerateTypeScriptServer(pbuf, "testdata", file, dir, TsRuntimeProtobufJs); err != nil {
+				t.Fatal(err)
+			}
+			common, err := filepath.Glob(filepath.Join("testdata", "ts", "common", "*"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			args := []string{"--import", "./register.mjs", "--experimental-transform-types", "--no-warnings", "--test"}
+			for _, file := range append(common, tests...) {
+				copyTestFile(t, file, filepath.Join(dir, filepath.Base(file)))
+			}
+			for _, file := range tests {
+				args = append(args, filepath.Base(file))
+			}
+			cmd := exec.Command("node", args...)
+			cmd.Dir = dir
+			if out, err := cmd.CombinedOutput(); err != nil {
+				t.Fatalf("node %v: %v\n%s", args, err, out)
+			}
+		})
+	}
+}
+
+func TestReactHooksFollowIdempotency(t *testing.T) {
+	file := filepath.Join("testdata", "full.proto")
+	dir := t.TempDir()
+	if err := GenerateTypeScriptReactHooks(parseTestProto(t, file), "testdata", file, dir, TsRuntimeProtobufJs); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "react-hooks_gen.ts"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := string(data)
+	// methods without side effects get a query hook, all others a mutation hook
+	for hook, expected := range map[string]bool{
+		"useGetUser(":         true,
+		"useGetUserMutation(": false,
+		"usePing(":            true,
+		"usePingMutation(":    false,
+		"useSetUser(":         false,
+		"useSetUserMutation(": true,
+		"useLimited(":         false,
+		"useLimitedMutation(": true,
+	} {
+		if strings.Contains(code, "export function "+hook) != expected {
+			t.Errorf("hook %s generated: %v, expected %v", hook, !expected, expected)
+		}
+	}
+}
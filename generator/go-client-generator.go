@@ -0,0 +1,451 @@
+package generator
+
+import (
+	"fmt"
+	"go/format"
+	"strings"
+
+	"github.com/yoheimuta/go-protoparser/v4"
+	"github.com/yoheimuta/go-protoparser/v4/interpret/unordered"
+	"github.com/yoheimuta/go-protoparser/v4/parser"
+)
+
+func GenerateGoClient(pbuf *parser.Proto, goBaseDir string, pkg string) error {
+	pb, err := protoparser.UnorderedInterpret(pbuf)
+	if err != nil {
+		return err
+	}
+
+	code, err := generateGoClient(pb, pkg)
+	if err != nil {
+		return fmt.Errorf("error generating go code: %v \n%s", err, code)
+	}
+
+	filename := fmt.Sprintf("%s/%s/%s.go", goBaseDir, pkg, "client_gen")
+	err = writeFile(filename, code)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// generateGoClient generates a client calling the rpc services and receiving the push messages of the ssp services
+func generateGoClient(pb *unordered.Proto, pkg string) (string, error) {
+	var sb strings.Builder
+	w := func(s string) { sb.WriteString(s + "\n") }
+
+	w("package " + pkg + "\n")
+	w(`import (
+		"context"
+		"errors"
+		"net/url"
+		"strconv"
+		"sync"
+		"time"
+
+		"google.golang.org/protobuf/proto"
+	)
+	`)
+	w(generatorWarning)
+
+	w(`// ClientSocket is the client side of a connection.
+	// Read blocks until the next binary message arrives.
+	type ClientSocket interface {
+		WriteBinary(msg []byte) error
+		Read() ([]byte, error)
+		Close() error
+	}
+
+	type clientResponse struct {
+		header url.Values
+		data []byte
+		err error
+	}
+
+	type outgoingMetadataKey struct{}
+
+	// WithOutgoingMetadata returns a context whose metadata is sent with every request made by the Client
+	func WithOutgoingMetadata(ctx context.Context, md Metadata) context.Context {
+		merged := make(Metadata)
+		if existing, ok := ctx.Value(outgoingMetadataKey{}).(Metadata); ok {
+			for k, v := range existing {
+				merged[k] = v
+			}
+		}
+		for k, v := range md {
+			merged[k] = v
+		}
+		return context.WithValue(ctx, outgoingMetadataKey{}, merged)
+	}
+
+	type responseMetadataKey struct{}
+
+	// CaptureResponseMetadata returns a context for which the Client copies the metadata of the response into md
+	func CaptureResponseMetadata(ctx context.Context, md Metadata) context.Context {
+		return context.WithValue(ctx, responseMetadataKey{}, md)
+	}
+
+	type clientListener struct {
+		cb func(data []byte)
+	}
+
+	// Client calls rpc services and receives push messages over a ClientSocket
+	type Client struct {
+		ws ClientSocket
+		log Logger
+		writeMutex sync.Mutex
+		mutex sync.Mutex
+		nextRequestId int
+		pending map[int]chan clientResponse
+		listeners map[string][]*clientListener
+		closed bool
+		done chan struct{}
+		err error
+	`)
+	for _, srv := range pb.ProtoBody.Services {
+		if hasServiceOption(srv, "(is_rpc)") || hasServiceOption(srv, "(is_ssp)") {
+			w(srv.ServiceName + " *" + srv.ServiceName + "Client")
+		}
+	}
+	w("}\n")
+
+	w(`// NewClient creates a client and starts reading from the socket. The log may be nil.
+	func NewClient(ws ClientSocket, log Logger) *Client {
+		if log == nil {
+			log = discardLogger{}
+		}
+		c := &Client{
+			ws: ws,
+			log: log,
+			nextRequestId: 1,
+			pending: make(map[int]chan clientResponse),
+			listeners: make(map[string][]*clientListener),
+			done: make(chan struct{}),
+		}`)
+	for _, srv := range pb.ProtoBody.Services {
+		if hasServiceOption(srv, "(is_rpc)") || hasServiceOption(srv, "(is_ssp)") {
+			w("c." + srv.ServiceName + " = &" + srv.ServiceName + "Client{client: c}")
+		}
+	}
+	w(`	go c.readLoop()
+		return c
+	}
+
+	// Close closes the socket and fails all pending calls
+	func (c *Client) Close() error {
+		return c.ws.Close()
+	}
+
+	// Done is closed once the read loop has ended
+	func (c *Client) Done() <-chan struct{} {
+		return c.done
+	}
+
+	// Err returns the error which ended the read loop
+	func (c *Client) Err() error {
+		<-c.done
+		return c.err
+	}
+
+	func (c *Client) readLoop() {
+		var err error
+		for {
+			var data []byte
+			data, err = c.ws.Read()
+			if err != nil {
+				break
+			}
+			c.dispatch(data)
+		}
+
+		c.mutex.Lock()
+		c.closed = true
+		c.err = err
+		pending := c.pending
+		c.pending = make(map[int]chan clientResponse)
+		c.mutex.Unlock()
+		for _, ch := range pending {
+			ch <- clientResponse{err: ErrConnClosed}
+		}
+		close(c.done)
+	}
+
+	// dispatch handles a response (starting with the optional header and the request id) or a push message (starting with the name)
+	func (c *Client) dispatch(data []byte) {
+		var header url.Values
+		if len(data) > 0 && data[0] == '?' {
+			headerLen := 0
+			for i := 0; i < len(data); i++ {
+				if data[i] == 0 || data[i] == 255 {
+					headerLen = i
+					break
+				}
+			}
+			if headerLen == 0 {
+				c.log.Logf("Dropping response without request id (%d bytes)", len(data))
+				return
+			}
+			var err error
+			if header, err = url.ParseQuery(string(data[1:headerLen])); err != nil {
+				c.log.Logf("Dropping response with invalid header: %v", err)
+				return
+			}
+			data = data[headerLen:]
+		}
+
+		if len(data) >= 4 && (data[0] == 0 || data[0] == 255) {
+			requestId := byteArrayToInt(data[:4])
+			c.resolve(requestId, header, data[4:])
+			return
+		}
+
+		for i := 0; i < len(data); i++ {
+			if data[i] == 0 {
+				c.notify(string(data[:i]), data[i+1:])
+				return
+			}
+		}
+		c.log.Logf("Dropping invalid message (%d bytes)", len(data))
+	}
+
+	func (c *Client) resolve(requestId int, header url.Values, data []byte) {
+		id := requestId
+		if id < 0 {
+			id = -id
+		}
+		c.mutex.Lock()
+		ch, exists := c.pending[id]
+		delete(c.pending, id)
+		c.mutex.Unlock()
+		if !exists {
+			c.log.Logf("No pending request for id %d", id)
+			return
+		}
+
+		if requestId > 0 {
+			ch <- clientResponse{header: header, data: data}
+			return
+		}
+		errResponse := &` + errorTypeName + `{}
+		if err := proto.Unmarshal(data, errResponse); err != nil {
+			ch <- clientResponse{header: header, err: err}
+			return
+		}
+		if code := header.Get("code"); code != "" {
+			details := metadataFromValues(header)
+			delete(details, "code")
+			ch <- clientResponse{header: header, err: &StatusError{Code: Code(code), Message: errResponse.Error, Details: details}}
+			return
+		}
+		ch <- clientResponse{header: header, err: errors.New(errResponse.Error)}
+	}
+
+	func (c *Client) notify(name string, data []byte) {
+		c.mutex.Lock()
+		listeners := c.listeners[name]
+		c.mutex.Unlock()
+		if len(listeners) == 0 {
+			c.log.Logf("No listener for: %s", name)
+			return
+		}
+		for _, l := range listeners {
+			l.cb(data)
+		}
+	}
+
+	// listen registers a callback for a push message and returns a function removing it
+	func (c *Client) listen(name string, cb func(data []byte)) func() {
+		l := &clientListener{cb: cb}
+		c.mutex.Lock()
+		c.listeners[name] = append(c.listeners[name], l)
+		c.mutex.Unlock()
+
+		return func() {
+			c.mutex.Lock()
+			defer c.mutex.Unlock()
+			listeners := c.listeners[name]
+			for i, other := range listeners {
+				if other == l {
+					c.listeners[name] = append(listeners[:i:i], listeners[i+1:]...)
+					break
+				}
+			}
+		}
+	}
+
+	// rpc sends a request and waits for the response
+	func (c *Client) rpc(ctx context.Context, name string, data []byte) ([]byte, error) {
+		ch := make(chan clientResponse, 1)
+		c.mutex.Lock()
+		if c.closed {
+			c.mutex.Unlock()
+			return nil, ErrConnClosed
+		}
+		requestId := c.nextRequestId
+		// ids need to fit into 3 bytes, as the first byte terminates the name of the request
+		c.nextRequestId = c.nextRequestId%0xFFFFFF + 1
+		c.pending[requestId] = ch
+		c.mutex.Unlock()
+
+		header := url.Values{}
+		if md, ok := ctx.Value(outgoingMetadataKey{}).(Metadata); ok {
+			header = md.values()
+		}
+		if span := SpanFromContext(ctx); span != nil && span.SpanContext().IsValid() {
+			header.Set("traceparent", span.SpanContext().Traceparent())
+		}
+		if deadline, ok := ctx.Deadline(); ok {
+			// the timeout is sent relative to the deadline to be independent of clock skew
+			timeout := time.Until(deadline)
+			if timeout <= 0 {
+				c.forget(requestId)
+				return nil, context.DeadlineExceeded
+			}
+			header.Set("timeout-ms", strconv.FormatInt((timeout+time.Millisecond-1).Milliseconds(), 10))
+		}
+		request := encodeRequest(name, header, requestId, data)
+		c.writeMutex.Lock()
+		err := c.ws.WriteBinary(request)
+		c.writeMutex.Unlock()
+		if err != nil {
+			c.forget(requestId)
+			return nil, err
+		}
+
+		select {
+		case resp := <-ch:
+			if md, ok := ctx.Value(responseMetadataKey{}).(Metadata); ok {
+				for k := range resp.header {
+					md[k] = resp.header.Get(k)
+				}
+			}
+			return resp.data, resp.err
+		case <-ctx.Done():
+			c.forget(requestId)
+			// let the server cancel the handler, the response is not awaited anymore
+			c.writeMutex.Lock()
+			c.ws.WriteBinary(encodeRequest(cancelName, nil, requestId, nil))
+			c.writeMutex.Unlock()
+			return nil, ctx.Err()
+		}
+	}
+
+	// Authenticate performs the handshake with the credentials.
+	// Until it succeeds, the server rejects all rpc calls and sends no push messages.
+	func (c *Client) Authenticate(ctx context.Context, credentials Metadata) error {
+		ctx = WithOutgoingMetadata(ctx, credentials)
+		ctx = WithOutgoingMetadata(ctx, Metadata{"schema": SchemaFingerprint})
+		_, err := c.rpc(ctx, handshakeName, nil)
+		return err
+	}
+
+	func (c *Client) forget(requestId int) {
+		c.mutex.Lock()
+		delete(c.pending, requestId)
+		c.mutex.Unlock()
+	}
+	`)
+
+	for _, srv := range pb.ProtoBody.Services {
+		isRpc := hasServiceOption(srv, "(is_rpc)")
+		if !isRpc && !hasServiceOption(srv, "(is_ssp)") {
+			continue
+		}
+
+		if isRpc {
+			w("// " + srv.ServiceName + "Client calls the rpc service " + srv.ServiceName)
+		} else {
+			w("// " + srv.ServiceName + "Client receives the push messages of " + srv.ServiceName)
+		}
+		w("type " + srv.ServiceName + `Client struct {
+			client *Client
+		}
+		`)
+
+		for _, rpc := range srv.ServiceBody.RPCs {
+			for _, c := range rpc.Comments {
+				w(c.Raw)
+			}
+			if isRpc {
+				w(generateGoClientMethod(srv, rpc.RPCName, rpc.RPCRequest.MessageType, rpc.RPCResponse.MessageType))
+			} else {
+				w(generateGoClientListener(srv, rpc.RPCName, rpc.RPCRequest.MessageType))
+			}
+		}
+	}
+
+	formattedCode, err := format.Source([]byte(sb.String()))
+	if err != nil {
+		return sb.String(), err
+	}
+	return string(formattedCode), nil
+}
+
+// generateGoClientMethod generates a typed method calling an rpc
+func generateGoClientMethod(srv *unordered.Service, name string, requestType string, responseType string) string {
+	var sb strings.Builder
+	w := func(s string) { sb.WriteString(s + "\n") }
+
+	signature := "func (s *" + srv.ServiceName + "Client) " + name + "(ctx context.Context"
+	if requestType != voidTypeName {
+		signature += ", param *" + requestType
+	}
+	signature += ")"
+	if responseType != voidTypeName {
+		signature += " (*" + responseType + ", error)"
+	} else {
+		signature += " error"
+	}
+	w(signature + " {")
+
+	errReturn := "return err"
+	if responseType != voidTypeName {
+		errReturn = "return nil, err"
+	}
+
+	if requestType != voidTypeName {
+		w(`	data, err := proto.Marshal(param)
+			if err != nil {
+				` + errReturn + `
+			}`)
+	} else {
+		w("	var data []byte")
+	}
+
+	if responseType != voidTypeName {
+		w(`	responseData, err := s.client.rpc(ctx, "` + name + `", data)
+			if err != nil {
+				return nil, err
+			}
+			resp := &` + responseType + `{}
+			if err := proto.Unmarshal(responseData, resp); err != nil {
+				return nil, err
+			}
+			return resp, nil
+		}`)
+	} else {
+		assign := ":="
+		if requestType != voidTypeName {
+			assign = "="
+		}
+		w(`	_, err ` + assign + ` s.client.rpc(ctx, "` + name + `", data)
+			return err
+		}`)
+	}
+	return sb.String()
+}
+
+// generateGoClientListener generates a typed subscription for a push message
+func generateGoClientListener(srv *unordered.Service, name string, messageType string) string {
+	return "func (s *" + srv.ServiceName + "Client) On" + firstCharToUpper(name) + "(cb func(p *" + messageType + ")) (unsubscribe func()) {\n" +
+		`	return s.client.listen("` + name + `", func(data []byte) {
+			p := &` + messageType + `{}
+			if err := proto.Unmarshal(data, p); err != nil {
+				s.client.log.Logf("Error decoding push message '` + name + `': %v", err)
+				return
+			}
+			cb(p)
+		})
+	}
+	`
+}
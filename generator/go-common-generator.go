@@ -6,6 +6,7 @@ import (
 	"strings"
 
 	"github.com/yoheimuta/go-protoparser/v4/interpret/unordered"
+	"github.com/yoheimuta/go-protoparser/v4/parser"
 )
 
 func GenerateGoCommon(goBaseDir string, pkg string) error {
@@ -32,7 +33,16 @@ func generateGoCommonCode(pkg string) (string, error) {
 
 	w(`import (
 		"bytes"
+		"context"
 		"encoding/binary"
+		"errors"
+		"fmt"
+		"log/slog"
+		"net/url"
+		"strconv"
+		"strings"
+		"sync"
+		"time"
 	)
 	`)
 
@@ -46,20 +56,349 @@ func generateGoCommonCode(pkg string) (string, error) {
 	}
 	`)
 
+	w(`// Session holds typed per-connection state. It is safe for concurrent access.
+	type Session struct {
+		mutex  sync.RWMutex
+		values map[any]any
+	}
+
+	func NewSession() *Session {
+		return &Session{values: make(map[any]any)}
+	}
+
+	// SessionKey identifies a value of type T in a Session.
+	// Keys are compared by identity, so two keys with the same name never collide.
+	type SessionKey[T any] struct {
+		name string
+	}
+
+	func NewSessionKey[T any](name string) *SessionKey[T] {
+		return &SessionKey[T]{name: name}
+	}
+
+	func (k *SessionKey[T]) Name() string {
+		return k.name
+	}
+
+	// Get returns the value stored for the key or the zero value if there is none
+	func (k *SessionKey[T]) Get(s *Session) (value T, exists bool) {
+		s.mutex.RLock()
+		defer s.mutex.RUnlock()
+		v, exists := s.values[k]
+		if !exists {
+			return value, false
+		}
+		// a nil interface value fails the assertion, but was stored
+		value, _ = v.(T)
+		return value, true
+	}
+
+	func (k *SessionKey[T]) Set(s *Session, value T) {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		s.values[k] = value
+	}
+
+	func (k *SessionKey[T]) Delete(s *Session) {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		delete(s.values, k)
+	}
+	`)
+
 	w(`type Logger interface {
 		Log(str string)
 		Logf(format string, a ...any)
 	}
+
+	// StructuredLogger logs messages with attributes. It is implemented by *slog.Logger.
+	type StructuredLogger interface {
+		LogAttrs(ctx context.Context, level slog.Level, msg string, attrs ...slog.Attr)
+	}
+
+	// LogLevels are the levels of the structured logs
+	type LogLevels struct {
+		Request slog.Level
+		Response slog.Level
+		Push slog.Level
+		Failure slog.Level
+	}
+
+	var DefaultLogLevels = LogLevels{
+		Request: slog.LevelDebug,
+		Response: slog.LevelDebug,
+		Push: slog.LevelDebug,
+		Failure: slog.LevelError,
+	}
+
+	// structuredLogAdapter logs free-form messages to a StructuredLogger
+	type structuredLogAdapter struct {
+		log StructuredLogger
+		level slog.Level
+	}
+
+	// NewStructuredLogAdapter creates a Logger writing free-form messages with the level to a StructuredLogger
+	func NewStructuredLogAdapter(log StructuredLogger, level slog.Level) Logger {
+		return &structuredLogAdapter{log: log, level: level}
+	}
+
+	func (l *structuredLogAdapter) Log(str string) {
+		l.log.LogAttrs(context.Background(), l.level, str)
+	}
+
+	func (l *structuredLogAdapter) Logf(format string, a ...any) {
+		l.log.LogAttrs(context.Background(), l.level, fmt.Sprintf(format, a...))
+	}
+
+	type discardLogger struct{}
+
+	func (discardLogger) Log(str string) {}
+	func (discardLogger) Logf(format string, a ...any) {}
+	`)
+
+	w(`// Code classifies the result of an rpc call
+	type Code string
+
+	const (
+		CodeOK Code = "ok"
+		CodeCanceled Code = "canceled"
+		CodeUnknown Code = "unknown"
+		CodeInvalidArgument Code = "invalid_argument"
+		CodeDeadlineExceeded Code = "deadline_exceeded"
+		CodeNotFound Code = "not_found"
+		CodePermissionDenied Code = "permission_denied"
+		CodeResourceExhausted Code = "resource_exhausted"
+		CodeUnimplemented Code = "unimplemented"
+		CodeInternal Code = "internal"
+		CodeUnavailable Code = "unavailable"
+		CodeUnauthenticated Code = "unauthenticated"
+		CodeFailedPrecondition Code = "failed_precondition"
+	)
+
+	// StatusError is an error with a Code. Handlers return it to report a specific code.
+	type StatusError struct {
+		Code Code
+		Message string
+		// Details are sent to the client as metadata of the error response
+		Details Metadata
+	}
+
+	func NewStatusError(code Code, format string, a ...any) *StatusError {
+		return &StatusError{Code: code, Message: fmt.Sprintf(format, a...)}
+	}
+
+	func (e *StatusError) Error() string {
+		return e.Message
+	}
+
+	// MethodDescriptor describes an rpc method and the options it is annotated with
+	type MethodDescriptor struct {
+		Service string
+		Method string
+		// Auth is the value of the (auth) option, e.g. the required role
+		Auth string
+		// Public is set by the (public) option. Public methods are never passed to the Authorizer.
+		Public bool
+		// Timeout is set by the (timeout) option, e.g. "5s". It applies if the client sends no timeout.
+		Timeout time.Duration
+		// RateLimit is set by the (rate_limit) option, e.g. "10/s"
+		RateLimit *RateLimit
+		// Options holds all options of the method by name without parentheses
+		Options map[string]string
+	}
+
+	// CodeOf returns the code of an error. It is CodeOK for nil and CodeUnknown for errors without a code.
+	func CodeOf(err error) Code {
+		var statusErr *StatusError
+		switch {
+		case err == nil:
+			return CodeOK
+		case errors.As(err, &statusErr):
+			return statusErr.Code
+		case errors.Is(err, context.Canceled):
+			return CodeCanceled
+		case errors.Is(err, context.DeadlineExceeded):
+			return CodeDeadlineExceeded
+		default:
+			return CodeUnknown
+		}
+	}
 	`)
 
 	// Write generic send data function
-	w(`func sendPushMessage(ws WebSocket, name string, log Logger, data []byte) {
-		log.Logf("Sending push message '%s' (%d bytes)", name, len(data))
+	w(`func sendPushMessage(ws WebSocket, service string, name string, log Logger, data []byte) {
+		if _, ok := ws.(pushSender); !ok {
+			log.Logf("Sending push message '%s' (%d bytes)", name, len(data))
+		}
+		sendPushFrame(ws, service, name, pushFrame(name, data))
+	}
+
+	// pushSender is implemented by sockets which trace and record the push messages sent through them
+	type pushSender interface {
+		sendPush(service string, name string, payload []byte) error
+	}
+
+	func sendPushFrame(ws WebSocket, service string, name string, payload []byte) error {
+		if sender, ok := ws.(pushSender); ok {
+			return sender.sendPush(service, name, payload)
+		}
+		return ws.WriteBinary(payload)
+	}
+
+	// pushFrame creates the binary representation of a push message
+	func pushFrame(name string, data []byte) []byte {
 		l := len(name) + 1 + len(data)
 		payload := make([]byte, l)
 		copy(payload, []byte(name))
 		copy(payload[len(name)+1:], data)
-		ws.WriteBinary(payload)
+		return payload
+	}
+	`)
+
+	w(`// parseRequest splits an incoming rpc frame into the function name, the header, the request id and the payload.
+	// The header is an optional url encoded query appended to the name, e.g. "Name?traceparent=..."
+	func parseRequest(inData []byte) (name string, header url.Values, requestId int, data []byte, err error) {
+		nameLen := 0
+		for i := 0; i < len(inData); i++ {
+			if inData[i] == 0 {
+				nameLen = i
+				break
+			}
+		}
+		if nameLen == 0 || len(inData) < nameLen+4 {
+			return "", nil, 0, nil, fmt.Errorf("invalid rpc frame (%d bytes)", len(inData))
+		}
+		name = string(inData[:nameLen])
+		if i := strings.IndexByte(name, '?'); i >= 0 {
+			header, err = url.ParseQuery(name[i+1:])
+			if err != nil {
+				return "", nil, 0, nil, fmt.Errorf("invalid rpc header: %v", err)
+			}
+			name = name[:i]
+		}
+		requestId = byteArrayToInt(inData[nameLen : nameLen+4])
+		return name, header, requestId, inData[nameLen+4:], nil
+	}
+
+	// handshakeName is the reserved name of the request authenticating a connection.
+	// The credentials are sent as metadata.
+	const handshakeName = "$hello"
+
+	// cancelName is the reserved name of the request canceling the running request with the same id
+	const cancelName = "$cancel"
+
+	// encodeRequest creates the binary representation of an rpc request
+	func encodeRequest(name string, header url.Values, requestId int, data []byte) []byte {
+		if len(header) > 0 {
+			name += "?" + header.Encode()
+		}
+		request := append([]byte(name), intToByteArray(requestId)...)
+		return append(request, data...)
+	}
+
+	// encodeResponse creates the binary representation of an rpc response.
+	// The header is prepended as url encoded query, e.g. "?key=value". Negative ids mark errors.
+	func encodeResponse(requestId int, header url.Values, data []byte) []byte {
+		var response []byte
+		if len(header) > 0 {
+			response = []byte("?" + header.Encode())
+		}
+		response = append(response, intToByteArray(requestId)...)
+		return append(response, data...)
+	}
+	`)
+
+	w(`// Metadata are key/value pairs sent along with a request or a response
+	type Metadata map[string]string
+
+	func (md Metadata) values() url.Values {
+		values := make(url.Values, len(md))
+		for k, v := range md {
+			values.Set(k, v)
+		}
+		return values
+	}
+
+	func metadataFromValues(values url.Values) Metadata {
+		md := make(Metadata, len(values))
+		for k := range values {
+			md[k] = values.Get(k)
+		}
+		return md
+	}
+
+	// requestState holds the metadata of the request currently handled
+	type requestState struct {
+		incoming Metadata
+		mutex sync.Mutex
+		response Metadata
+	}
+
+	type requestStateKey struct{}
+
+	func newRequestContext(ctx context.Context, header url.Values) context.Context {
+		return context.WithValue(ctx, requestStateKey{}, &requestState{
+			incoming: metadataFromValues(header),
+			response: make(Metadata),
+		})
+	}
+
+	// IncomingMetadata returns the metadata of the request handled with ctx
+	func IncomingMetadata(ctx context.Context) Metadata {
+		state, ok := ctx.Value(requestStateKey{}).(*requestState)
+		if !ok {
+			return Metadata{}
+		}
+		return state.incoming
+	}
+
+	// SetResponseMetadata adds metadata to the response of the request handled with ctx
+	func SetResponseMetadata(ctx context.Context, key string, value string) {
+		state, ok := ctx.Value(requestStateKey{}).(*requestState)
+		if !ok {
+			return
+		}
+		state.mutex.Lock()
+		defer state.mutex.Unlock()
+		state.response[key] = value
+	}
+
+	func responseHeader(ctx context.Context) url.Values {
+		state, ok := ctx.Value(requestStateKey{}).(*requestState)
+		if !ok {
+			return nil
+		}
+		state.mutex.Lock()
+		defer state.mutex.Unlock()
+		return state.response.values()
+	}
+
+	// withRequestTimeout applies the timeout sent by the client, or the default timeout of the method
+	func withRequestTimeout(ctx context.Context, header url.Values, defaultTimeout time.Duration) (context.Context, context.CancelFunc) {
+		timeout := defaultTimeout
+		if ms, err := strconv.ParseInt(header.Get("timeout-ms"), 10, 64); err == nil && ms > 0 {
+			timeout = time.Duration(ms) * time.Millisecond
+		}
+		if timeout <= 0 {
+			return ctx, func() {}
+		}
+		return context.WithTimeout(ctx, timeout)
+	}
+
+	// errorHeader returns the response header of a failed request, carrying the code of the error
+	func errorHeader(ctx context.Context, err error) url.Values {
+		header := responseHeader(ctx)
+		if header == nil {
+			header = url.Values{}
+		}
+		var statusErr *StatusError
+		if errors.As(err, &statusErr) {
+			for k, v := range statusErr.Details {
+				header.Set(k, v)
+			}
+		}
+		header.Set("code", string(CodeOf(err)))
+		return header
 	}
 	`)
 
@@ -93,9 +432,8 @@ func generateGoCommonCode(pkg string) (string, error) {
 	return string(formattedCode), nil
 }
 
-func generateGoInterface(srv *unordered.Service, name string, withError bool) string {
+func generateGoInterface(srv *unordered.Service, name string, withContext bool, withError bool) string {
 	var sb strings.Builder
-	w := func(s string) { sb.WriteString(s) }
 	wn := func(s string) { sb.WriteString(s + "\n") }
 
 	wn("type " + name + " interface {")
@@ -107,30 +445,40 @@ func generateGoInterface(srv *unordered.Service, name string, withError bool) st
 		for _, c := range rpc.Comments {
 			wn(c.Raw)
 		}
-		w(rpc.RPCName + "(")
+		wn(generateGoMethodSignature(rpc, withContext, withError))
+	}
+	wn("}")
 
-		// parameter
-		if rpc.RPCRequest.MessageType != voidTypeName {
-			w("param *" + rpc.RPCRequest.MessageType)
-		}
-		w(")")
+	return sb.String()
+}
 
-		// response
-		if rpc.RPCResponse.MessageType != voidTypeName {
-			if withError {
-				wn(" (*" + rpc.RPCResponse.MessageType + ", error)")
-			} else {
-				wn(" *" + rpc.RPCResponse.MessageType)
-			}
+// generateGoMethodSignature returns the name, parameters and results of an rpc as used in the service interfaces
+func generateGoMethodSignature(rpc *parser.RPC, withContext bool, withError bool) string {
+	var sb strings.Builder
+	w := func(s string) { sb.WriteString(s) }
+
+	w(rpc.RPCName + "(")
+
+	// parameter
+	var params []string
+	if withContext {
+		params = append(params, "ctx context.Context")
+	}
+	if rpc.RPCRequest.MessageType != voidTypeName {
+		params = append(params, "param *"+rpc.RPCRequest.MessageType)
+	}
+	w(strings.Join(params, ", "))
+	w(")")
+
+	// response
+	if rpc.RPCResponse.MessageType != voidTypeName {
+		if withError {
+			w(" (*" + rpc.RPCResponse.MessageType + ", error)")
 		} else {
-			if withError {
-				wn(" error")
-			} else {
-				wn("")
-			}
+			w(" *" + rpc.RPCResponse.MessageType)
 		}
+	} else if withError {
+		w(" error")
 	}
-	wn("}")
-
 	return sb.String()
 }
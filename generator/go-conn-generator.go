@@ -0,0 +1,679 @@
+package generator
+
+import (
+	"fmt"
+	"go/format"
+	"strings"
+
+	"github.com/yoheimuta/go-protoparser/v4"
+	"github.com/yoheimuta/go-protoparser/v4/interpret/unordered"
+	"github.com/yoheimuta/go-protoparser/v4/parser"
+)
+
+func GenerateGoConn(pbuf *parser.Proto, goBaseDir string, pkg string) error {
+	pb, err := protoparser.UnorderedInterpret(pbuf)
+	if err != nil {
+		return err
+	}
+
+	code, err := generateGoConn(pb, pkg)
+	if err != nil {
+		return fmt.Errorf("error generating go code: %v \n%s", err, code)
+	}
+
+	filename := fmt.Sprintf("%s/%s/%s.go", goBaseDir, pkg, "conn_gen")
+	err = writeFile(filename, code)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// generateGoConn generates the Conn type which owns a socket, runs the read loop,
+// instantiates all services for the connection and dispatches incoming rpc calls
+func generateGoConn(pb *unordered.Proto, pkg string) (string, error) {
+	var sb strings.Builder
+	w := func(s string) { sb.WriteString(s + "\n") }
+
+	w("package " + pkg + "\n")
+	w(`import (
+		"context"
+		"errors"
+		"io"
+		"log/slog"
+		"net/url"
+		"sync"
+		"sync/atomic"`)
+	if hasServicesWithOption(pb, "(is_rpc)") {
+		w(`"time"`)
+	}
+	w(`)
+	`)
+	w(generatorWarning)
+
+	w(`// Socket is a WebSocket which can be read from and closed.
+	// Read blocks until the next binary message arrives.
+	type Socket interface {
+		WebSocket
+		Read() ([]byte, error)
+		Close() error
+	}
+
+	var ErrConnClosed = errors.New("connection closed")
+
+	// Authorizer decides if the connection may call a method, before the request is decoded.
+	// Errors without a code are reported as permission_denied. Return a StatusError with
+	// CodeUnauthenticated if the connection has no identity yet.
+	type Authorizer func(ctx context.Context, c *Conn, method MethodDescriptor) error
+
+	// Authenticator validates the credentials of a handshake and returns the identity bound to the connection.
+	// Errors without a code are reported as unauthenticated.
+	type Authenticator func(ctx context.Context, c *Conn, credentials Metadata) (identity string, err error)
+	`)
+
+	// Config with all rpc handlers and the lifecycle hooks
+	w(`// ConnConfig holds the rpc handlers and lifecycle hooks shared by all connections
+	type ConnConfig struct {
+		// Log receives free-form messages. It defaults to Slog at debug level.
+		Log Logger
+		// Slog receives structured logs of all rpc calls and push messages, e.g. a *slog.Logger
+		Slog StructuredLogger
+		// LogLevels of the structured logs, DefaultLogLevels if nil
+		LogLevels *LogLevels
+		// Hub registers every connection for broadcasts while it is open
+		Hub *Hub
+		Metrics MetricsRecorder
+		// Tracer starts a span per rpc call and push message
+		Tracer Tracer
+		// Authorizer is called for every non-public method. Without it, methods with an (auth) option are denied.
+		Authorizer Authorizer
+		// RateLimiter limits the calls per connection, identity and method.
+		// Without it, only the (rate_limit) options of the methods are enforced.
+		RateLimiter *RateLimiter
+		// OnSchemaMismatch is called in the handshake if the client was generated from a different schema.
+		// Returning an error rejects the handshake. Without it, the mismatch is logged.
+		OnSchemaMismatch func(c *Conn, clientFingerprint string) error
+		// Authenticate enables the handshake. Until it succeeds all rpc calls are rejected and push messages are dropped.
+		Authenticate Authenticator
+		// ConcurrentRequests handles the requests of a connection concurrently.
+		// By default they are handled one after another in the order they were received.
+		ConcurrentRequests bool
+	`)
+	for _, srv := range pb.ProtoBody.Services {
+		if hasServiceOption(srv, "(is_rpc)") {
+			w(srv.ServiceName + " " + srv.ServiceName)
+			w("// New" + srv.ServiceName + " creates a handler per connection and takes precedence over " + srv.ServiceName + ".")
+			w("// The handler is closed with the connection if it implements io.Closer.")
+			w("New" + srv.ServiceName + " func(c *Conn) " + srv.ServiceName)
+		}
+	}
+	w(`
+		// OnConnect is called before the read loop starts. Returning an error closes the connection.
+		OnConnect func(c *Conn) error
+		// OnDisconnect is called once the connection has been closed
+		OnDisconnect func(c *Conn, err error)
+	}
+	`)
+
+	// Conn with all ssp services
+	w(`var lastConnId atomic.Uint64
+
+	// Conn owns a socket and all services bound to it
+	type Conn struct {
+		id uint64
+		ws Socket
+		cfg *ConnConfig
+		log Logger
+		slog StructuredLogger
+		logLevels *LogLevels
+		metrics MetricsRecorder
+		ctx context.Context
+		cancel context.CancelFunc
+		writeMutex sync.Mutex
+		closeOnce sync.Once
+		closed atomic.Bool
+		session *Session
+		handlers []any
+		requestMutex sync.Mutex
+		requests map[int]*inFlightRequest
+		requestGroup sync.WaitGroup
+		requestQueue requestQueue
+		rateLimiter *connRateLimiter
+		authMutex sync.RWMutex
+		authenticated bool
+		identity string
+	`)
+	for _, srv := range pb.ProtoBody.Services {
+		if hasServiceOption(srv, "(is_rpc)") {
+			w(firstCharToLower(srv.ServiceName) + " " + srv.ServiceName)
+		}
+	}
+	for _, srv := range pb.ProtoBody.Services {
+		if hasServiceOption(srv, "(is_ssp)") {
+			w(srv.ServiceName + " " + srv.ServiceName)
+		}
+	}
+	w("}\n")
+
+	w(`func NewConn(ws Socket, cfg *ConnConfig) *Conn {
+		c := &Conn{
+			id: lastConnId.Add(1),
+			ws: ws,
+			cfg: cfg,
+			log: cfg.Log,
+			slog: cfg.Slog,
+			logLevels: cfg.LogLevels,
+			session: NewSession(),
+			requests: make(map[int]*inFlightRequest),
+			metrics: cfg.Metrics,
+		}
+		if c.logLevels == nil {
+			c.logLevels = &DefaultLogLevels
+		}
+		if c.log == nil {
+			if c.slog != nil {
+				c.log = NewStructuredLogAdapter(c.slog, slog.LevelDebug)
+			} else {
+				c.log = discardLogger{}
+			}
+		}
+		if c.metrics == nil {
+			c.metrics = noopMetrics{}
+		}
+		if cfg.RateLimiter != nil {
+			c.rateLimiter = cfg.RateLimiter.newConnRateLimiter()
+		} else {
+			c.rateLimiter = NewRateLimiter(RateLimits{}).newConnRateLimiter()
+		}
+		c.ctx, c.cancel = context.WithCancel(context.WithValue(context.Background(), connContextKey{}, c))`)
+	for _, srv := range pb.ProtoBody.Services {
+		if hasServiceOption(srv, "(is_ssp)") {
+			w("c." + srv.ServiceName + " = New" + srv.ServiceName + "(c, c.log)")
+		}
+	}
+	for _, srv := range pb.ProtoBody.Services {
+		if hasServiceOption(srv, "(is_rpc)") {
+			field := "c." + firstCharToLower(srv.ServiceName)
+			w("if cfg.New" + srv.ServiceName + " != nil {")
+			w("	" + field + " = cfg.New" + srv.ServiceName + "(c)")
+			w("	c.handlers = append(c.handlers, " + field + ")")
+			w("} else {")
+			w("	" + field + " = cfg." + srv.ServiceName)
+			w("}")
+		}
+	}
+	w(`	return c
+	}
+	`)
+
+	w(`type connContextKey struct{}
+
+	// ConnFromContext returns the connection of the rpc call handled with ctx
+	func ConnFromContext(ctx context.Context) (*Conn, bool) {
+		c, ok := ctx.Value(connContextKey{}).(*Conn)
+		return c, ok
+	}
+
+	// Context returns the context of the connection, which is canceled when the connection is closed.
+	// All handlers are called with a context derived from it.
+	func (c *Conn) Context() context.Context {
+		return c.ctx
+	}
+
+	// ID returns the unique id of the connection
+	func (c *Conn) ID() uint64 {
+		return c.id
+	}
+
+	// Identity returns the identity bound by the handshake
+	func (c *Conn) Identity() (identity string, authenticated bool) {
+		c.authMutex.RLock()
+		defer c.authMutex.RUnlock()
+		return c.identity, c.authenticated
+	}
+
+	// Session returns the typed state of the connection
+	func (c *Conn) Session() *Session {
+		return c.session
+	}
+
+	func (c *Conn) Write(msg []byte) error {
+		c.writeMutex.Lock()
+		defer c.writeMutex.Unlock()
+		if c.closed.Load() {
+			return ErrConnClosed
+		}
+		return c.ws.Write(msg)
+	}
+
+	func (c *Conn) WriteBinary(msg []byte) error {
+		c.writeMutex.Lock()
+		defer c.writeMutex.Unlock()
+		if c.closed.Load() {
+			return ErrConnClosed
+		}
+		return c.ws.WriteBinary(msg)
+	}
+
+	func (c *Conn) sendPush(service string, name string, payload []byte) error {
+		if _, authenticated := c.Identity(); c.cfg.Authenticate != nil && !authenticated {
+			c.log.Logf("Dropping push message '%s' before authentication", name)
+			return nil
+		}
+		ctx, span := startSpan(c.ctx, c.cfg.Tracer, "push "+name, SpanContext{})
+		err := c.WriteBinary(payload)
+		if err == nil {
+			c.metrics.PushSent(service, name, len(payload))
+		}
+		if c.slog != nil {
+			attrs := []slog.Attr{slog.Uint64("conn", c.id), slog.String("service", service), slog.String("method", name), slog.Int("size", len(payload))}
+			if err != nil {
+				c.slog.LogAttrs(ctx, c.logLevels.Failure, "push failed", append(attrs, slog.String("error", err.Error()))...)
+			} else {
+				c.slog.LogAttrs(ctx, c.logLevels.Push, "push", attrs...)
+			}
+		} else {
+			c.log.Logf("Sending push message '%s' (%d bytes)", name, len(payload))
+		}
+		if span != nil {
+			span.SetAttribute("rpc.method", name)
+			span.SetAttribute("rpc.response_size", len(payload))
+			if err != nil {
+				span.RecordError(err)
+			}
+			span.End()
+		}
+		return err
+	}
+
+	func (c *Conn) Set(key string, value interface{}) {
+		c.ws.Set(key, value)
+	}
+
+	func (c *Conn) Get(key string) (value interface{}, exists bool) {
+		return c.ws.Get(key)
+	}
+	`)
+
+	w(`// Serve runs the read loop until the socket fails or the connection is closed.
+	// It returns nil if the connection was closed by Close.
+	func (c *Conn) Serve() error {
+		if c.cfg.OnConnect != nil {
+			if err := c.cfg.OnConnect(c); err != nil {
+				c.Close()
+				if c.cfg.Hub != nil {
+					// OnConnect may have joined topics
+					c.cfg.Hub.Remove(c)
+				}
+				c.disposeHandlers()
+				return err
+			}
+		}
+
+		c.metrics.ConnectionOpened()
+		if c.cfg.Hub != nil {
+			c.cfg.Hub.Add(c)
+		}
+
+		var err error
+		for {
+			var data []byte
+			data, err = c.ws.Read()
+			if err != nil {
+				break
+			}
+			c.dispatch(data)
+		}
+		if c.closed.Load() {
+			err = nil
+		}
+
+		c.Close()
+		c.requestGroup.Wait()
+		if c.cfg.Hub != nil {
+			c.cfg.Hub.Remove(c)
+		}
+		c.metrics.ConnectionClosed()
+		c.disposeHandlers()
+		if c.cfg.OnDisconnect != nil {
+			c.cfg.OnDisconnect(c, err)
+		}
+		return err
+	}
+
+	// Close closes the socket. All following writes fail with ErrConnClosed.
+	func (c *Conn) Close() error {
+		var err error
+		c.closeOnce.Do(func() {
+			c.writeMutex.Lock()
+			c.closed.Store(true)
+			c.writeMutex.Unlock()
+			c.cancel()
+			err = c.ws.Close()
+		})
+		return err
+	}
+
+	// inFlightRequest is a request which is queued or whose handler is running
+	type inFlightRequest struct {
+		cancel context.CancelFunc
+	}
+
+	// requestQueue runs the requests of a connection one after another in a single worker
+	type requestQueue struct {
+		mutex sync.Mutex
+		requests []func()
+		running bool
+	}
+
+	func (q *requestQueue) push(run func()) {
+		q.mutex.Lock()
+		defer q.mutex.Unlock()
+		q.requests = append(q.requests, run)
+		if !q.running {
+			q.running = true
+			go q.work()
+		}
+	}
+
+	func (q *requestQueue) work() {
+		for {
+			q.mutex.Lock()
+			if len(q.requests) == 0 {
+				q.running = false
+				q.mutex.Unlock()
+				return
+			}
+			run := q.requests[0]
+			q.requests[0] = nil
+			q.requests = q.requests[1:]
+			q.mutex.Unlock()
+			run()
+		}
+	}
+
+	// cancelRequest cancels the context of a running handler
+	func (c *Conn) cancelRequest(requestId int) {
+		c.requestMutex.Lock()
+		request, exists := c.requests[requestId]
+		c.requestMutex.Unlock()
+		if exists {
+			request.cancel()
+		}
+	}
+
+	func (c *Conn) finishRequest(requestId int, request *inFlightRequest) {
+		request.cancel()
+		c.requestMutex.Lock()
+		defer c.requestMutex.Unlock()
+		if c.requests[requestId] == request {
+			delete(c.requests, requestId)
+		}
+	}
+
+	// handshake validates the credentials and binds the identity to the connection.
+	// A failed handshake removes a previously bound identity.
+	func (c *Conn) handshake(requestId int, header url.Values) {
+		ctx := newRequestContext(c.ctx, header)
+		SetResponseMetadata(ctx, "schema", SchemaFingerprint)
+		if err := c.checkSchema(header.Get("schema")); err != nil {
+			c.log.Logf("Handshake failed: %v", err)
+			sendAndReturnError(ctx, c, requestId, err)
+			return
+		}
+
+		identity := ""
+		var err error
+		if c.cfg.Authenticate != nil {
+			identity, err = c.cfg.Authenticate(ctx, c, IncomingMetadata(ctx))
+			if err != nil && CodeOf(err) == CodeUnknown {
+				err = &StatusError{Code: CodeUnauthenticated, Message: err.Error()}
+			}
+		}
+
+		c.authMutex.Lock()
+		c.identity, c.authenticated = identity, err == nil
+		c.authMutex.Unlock()
+
+		if err != nil {
+			c.log.Logf("Handshake failed: %v", err)
+			sendAndReturnError(ctx, c, requestId, err)
+			return
+		}
+		c.WriteBinary(encodeResponse(requestId, responseHeader(ctx), nil))
+	}
+
+	// checkSchema compares the schema fingerprint sent by the client
+	func (c *Conn) checkSchema(clientFingerprint string) error {
+		if clientFingerprint == "" || clientFingerprint == SchemaFingerprint {
+			return nil
+		}
+		if c.cfg.OnSchemaMismatch == nil {
+			c.log.Logf("Client schema '%s' differs from server schema '%s'", clientFingerprint, SchemaFingerprint)
+			return nil
+		}
+		err := c.cfg.OnSchemaMismatch(c, clientFingerprint)
+		if err != nil && CodeOf(err) == CodeUnknown {
+			return &StatusError{Code: CodeFailedPrecondition, Message: err.Error()}
+		}
+		return err
+	}
+
+	// authorize checks the authentication, the rate limits and if the method may be called on this connection
+	func (c *Conn) authorize(ctx context.Context, method MethodDescriptor) error {
+		identity, authenticated := c.Identity()
+		if c.cfg.Authenticate != nil && !authenticated {
+			return NewStatusError(CodeUnauthenticated, "connection not authenticated")
+		}
+		if err := c.rateLimiter.allow(identity, authenticated, method); err != nil {
+			return err
+		}
+		if method.Public {
+			return nil
+		}
+		if c.cfg.Authorizer == nil {
+			if method.Auth != "" {
+				return NewStatusError(CodePermissionDenied, "no authorizer for '%s'", method.Method)
+			}
+			return nil
+		}
+		err := c.cfg.Authorizer(ctx, c, method)
+		if err != nil && CodeOf(err) == CodeUnknown {
+			return &StatusError{Code: CodePermissionDenied, Message: err.Error()}
+		}
+		return err
+	}
+
+	// disposeHandlers closes all handlers created for this connection
+	func (c *Conn) disposeHandlers() {
+		for _, h := range c.handlers {
+			if closer, ok := h.(io.Closer); ok {
+				if err := closer.Close(); err != nil {
+					c.log.Logf("Error closing handler: %v", err)
+				}
+			}
+		}
+		c.handlers = nil
+	}
+	`)
+
+	// Dispatch by rpc name
+	w(`func (c *Conn) dispatch(data []byte) {
+		name, header, requestId, _, err := parseRequest(data)
+		if err != nil {
+			c.log.Logf("Dropping message: %v", err)
+			return
+		}
+
+		switch name {
+		case handshakeName:
+			c.handshake(requestId, header)
+			return
+		case cancelName:
+			c.cancelRequest(requestId)
+			return
+		}
+
+`)
+	if !hasServicesWithOption(pb, "(is_rpc)") {
+		w(`c.log.Log("Invalid rpc call: \"" + name + "\"")
+		sendAndReturnError(c.ctx, c, requestId, NewStatusError(CodeUnimplemented, "invalid rpc call: %s", name))
+	}`)
+		return formatGoConn(sb.String())
+	}
+
+	w(`var service string
+		var method MethodDescriptor
+		var handle func(ctx context.Context, ws WebSocket) error
+		switch name {`)
+	// a name shared by several services is handled by the first of them with a handler
+	var rpcServices []*unordered.Service
+	owners := make(map[string][]*unordered.Service)
+	var sharedNames []string
+	for _, srv := range pb.ProtoBody.Services {
+		if !hasServiceOption(srv, "(is_rpc)") {
+			continue
+		}
+		rpcServices = append(rpcServices, srv)
+		for _, rpc := range srv.ServiceBody.RPCs {
+			if len(owners[rpc.RPCName]) == 1 {
+				sharedNames = append(sharedNames, rpc.RPCName)
+			}
+			owners[rpc.RPCName] = append(owners[rpc.RPCName], srv)
+		}
+	}
+	selectService := func(srv *unordered.Service) {
+		field := "c." + firstCharToLower(srv.ServiceName)
+		w("	service = \"" + srv.ServiceName + "\"")
+		w("	method = " + srv.ServiceName + "Methods[name]")
+		w("	handle = func(ctx context.Context, ws WebSocket) error {")
+		w("		return Handle" + srv.ServiceName + "Request(ctx, ws, " + field + ", c.log, data)")
+		w("	}")
+	}
+	for _, srv := range rpcServices {
+		var names []string
+		for _, rpc := range srv.ServiceBody.RPCs {
+			if len(owners[rpc.RPCName]) == 1 {
+				names = append(names, "\""+rpc.RPCName+"\"")
+			}
+		}
+		if len(names) == 0 {
+			continue
+		}
+		field := "c." + firstCharToLower(srv.ServiceName)
+		w("case " + strings.Join(names, ", ") + ":")
+		w("	if " + field + " == nil {")
+		w("		sendAndReturnError(c.ctx, c, requestId, NewStatusError(CodeUnimplemented, \"service '" + srv.ServiceName + "' not available\"))")
+		w("		return")
+		w("	}")
+		selectService(srv)
+	}
+	for _, name := range sharedNames {
+		var serviceNames []string
+		w("case \"" + name + "\":")
+		w("	switch {")
+		for _, srv := range owners[name] {
+			serviceNames = append(serviceNames, "'"+srv.ServiceName+"'")
+			w("	case c." + firstCharToLower(srv.ServiceName) + " != nil:")
+			selectService(srv)
+		}
+		w("	default:")
+		w("		sendAndReturnError(c.ctx, c, requestId, NewStatusError(CodeUnimplemented, \"services " + strings.Join(serviceNames, ", ") + " not available\"))")
+		w("		return")
+		w("	}")
+	}
+	w(`	default:
+			c.log.Log("Invalid rpc call: \"" + name + "\"")
+			sendAndReturnError(c.ctx, c, requestId, NewStatusError(CodeUnimplemented, "invalid rpc call: %s", name))
+			return
+		}
+
+		// the request is registered at once, so a cancel frame reaches it while it is queued or running
+		ctx, cancel := context.WithCancel(c.ctx)
+		request := &inFlightRequest{cancel: cancel}
+		c.requestMutex.Lock()
+		c.requests[requestId] = request
+		c.requestMutex.Unlock()
+		// the deadline starts when the request is received
+		ctx, cancelTimeout := withRequestTimeout(ctx, header, method.Timeout)
+		c.requestGroup.Add(1)
+		run := func() {
+			defer c.requestGroup.Done()
+			defer c.finishRequest(requestId, request)
+			defer cancelTimeout()
+
+			var err error
+			var remoteParent SpanContext
+			if traceparent := header.Get("traceparent"); traceparent != "" {
+				if remoteParent, err = ParseTraceparent(traceparent); err != nil {
+					c.log.Logf("Ignoring trace context: %v", err)
+				}
+			}
+			ctx, span := startSpan(ctx, c.cfg.Tracer, service+"/"+name, remoteParent)
+
+			attrs := []slog.Attr{
+				slog.Uint64("conn", c.id),
+				slog.String("service", service),
+				slog.String("method", name),
+				slog.Int("request_id", requestId),
+			}
+			if c.slog != nil {
+				c.slog.LogAttrs(ctx, c.logLevels.Request, "rpc request", append(attrs, slog.Int("size", len(data)))...)
+			} else {
+				c.log.Logf("Request: '%s'", name)
+			}
+
+			start := time.Now()
+			c.metrics.RequestStarted(service, name)
+			response := &byteCounter{WebSocket: c}
+			if err = c.authorize(newRequestContext(ctx, header), method); err != nil {
+				sendAndReturnError(ctx, response, requestId, err)
+			} else {
+				err = handle(withAuthorized(ctx), response)
+			}
+			duration := time.Since(start)
+			status := string(CodeOf(err))
+			c.metrics.RequestFinished(service, name, status, duration, len(data), response.bytes)
+
+			if c.slog != nil {
+				attrs = append(attrs, slog.Duration("duration", duration), slog.Int("size", response.bytes), slog.String("code", status))
+				if err != nil {
+					c.slog.LogAttrs(ctx, c.logLevels.Failure, "rpc failed", append(attrs, slog.String("error", err.Error()))...)
+				} else {
+					c.slog.LogAttrs(ctx, c.logLevels.Response, "rpc response", attrs...)
+				}
+			} else if err != nil {
+				c.log.Logf("Error in rpc '%s': %v", name, err)
+			}
+
+			if span != nil {
+				span.SetAttribute("rpc.service", service)
+				span.SetAttribute("rpc.method", name)
+				span.SetAttribute("rpc.request_id", requestId)
+				span.SetAttribute("rpc.status", status)
+				span.SetAttribute("rpc.request_size", len(data))
+				span.SetAttribute("rpc.response_size", response.bytes)
+				if err != nil {
+					span.RecordError(err)
+				}
+				span.End()
+			}
+		}
+
+		if c.cfg.ConcurrentRequests {
+			go run()
+		} else {
+			c.requestQueue.push(run)
+		}
+	}`)
+
+	return formatGoConn(sb.String())
+}
+
+func formatGoConn(code string) (string, error) {
+	formattedCode, err := format.Source([]byte(code))
+	if err != nil {
+		return code, err
+	}
+	return string(formattedCode), nil
+}
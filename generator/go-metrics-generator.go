@@ -0,0 +1,266 @@
+package generator
+
+import (
+	"fmt"
+	"go/format"
+	"strings"
+)
+
+func GenerateGoMetrics(goBaseDir string, pkg string) error {
+	code, err := generateGoMetrics(pkg)
+	if err != nil {
+		return fmt.Errorf("error generating go code: %v \n%s", err, code)
+	}
+
+	filename := fmt.Sprintf("%s/%s/%s.go", goBaseDir, pkg, "metrics_gen")
+	err = writeFile(filename, code)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// generateGoMetrics generates the MetricsRecorder interface and an implementation
+// exposing the metrics in the Prometheus text format
+func generateGoMetrics(pkg string) (string, error) {
+	var sb strings.Builder
+	w := func(s string) { sb.WriteString(s + "\n") }
+
+	w("package " + pkg + "\n")
+	w(`import (
+		"fmt"
+		"io"
+		"net/http"
+		"sort"
+		"strings"
+		"sync"
+		"time"
+	)
+	`)
+	w(generatorWarning)
+
+	w(`// MetricsRecorder receives metrics about rpc calls, push messages and connections
+	type MetricsRecorder interface {
+		RequestStarted(service string, method string)
+		RequestFinished(service string, method string, status string, duration time.Duration, requestBytes int, responseBytes int)
+		PushSent(service string, method string, bytes int)
+		ConnectionOpened()
+		ConnectionClosed()
+	}
+
+	type noopMetrics struct{}
+
+	func (noopMetrics) RequestStarted(service string, method string) {}
+	func (noopMetrics) RequestFinished(service string, method string, status string, duration time.Duration, requestBytes int, responseBytes int) {
+	}
+	func (noopMetrics) PushSent(service string, method string, bytes int) {}
+	func (noopMetrics) ConnectionOpened() {}
+	func (noopMetrics) ConnectionClosed() {}
+
+	// byteCounter counts the bytes written to a WebSocket
+	type byteCounter struct {
+		WebSocket
+		bytes int
+	}
+
+	func (b *byteCounter) WriteBinary(msg []byte) error {
+		b.bytes += len(msg)
+		return b.WebSocket.WriteBinary(msg)
+	}
+	`)
+
+	w(`var (
+		durationBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+		sizeBuckets = []float64{64, 256, 1024, 4096, 16384, 65536, 262144, 1048576}
+	)
+
+	type histogram struct {
+		buckets []float64
+		counts []uint64
+		sum float64
+		count uint64
+	}
+
+	func newHistogram(buckets []float64) *histogram {
+		return &histogram{buckets: buckets, counts: make([]uint64, len(buckets))}
+	}
+
+	func (h *histogram) observe(v float64) {
+		for i, upper := range h.buckets {
+			if v <= upper {
+				h.counts[i]++
+			}
+		}
+		h.sum += v
+		h.count++
+	}
+
+	func (h *histogram) write(out io.Writer, name string, labels string) {
+		for i, upper := range h.buckets {
+			fmt.Fprintf(out, "%s_bucket{%sle=\"%g\"} %d\n", name, labels, upper, h.counts[i])
+		}
+		fmt.Fprintf(out, "%s_bucket{%sle=\"+Inf\"} %d\n", name, labels, h.count)
+		fmt.Fprintf(out, "%s_sum{%s} %g\n", name, strings.TrimSuffix(labels, ","), h.sum)
+		fmt.Fprintf(out, "%s_count{%s} %d\n", name, strings.TrimSuffix(labels, ","), h.count)
+	}
+
+	// methodKey identifies a method, as services may share method names
+	type methodKey struct {
+		service string
+		method string
+	}
+
+	func (k methodKey) labels() string {
+		return fmt.Sprintf("service=%q,method=%q,", k.service, k.method)
+	}
+
+	func (k methodKey) less(other methodKey) bool {
+		if k.service != other.service {
+			return k.service < other.service
+		}
+		return k.method < other.method
+	}
+
+	type requestKey struct {
+		methodKey
+		status string
+	}
+
+	// PrometheusMetrics collects metrics and serves them in the Prometheus text exposition format.
+	// Mount it on /metrics.
+	type PrometheusMetrics struct {
+		mutex sync.Mutex
+		requests map[requestKey]uint64
+		inFlight map[methodKey]int64
+		durations map[methodKey]*histogram
+		requestSizes map[methodKey]*histogram
+		responseSizes map[methodKey]*histogram
+		pushes map[methodKey]uint64
+		pushSizes map[methodKey]*histogram
+		connections int64
+	}
+
+	func NewPrometheusMetrics() *PrometheusMetrics {
+		return &PrometheusMetrics{
+			requests: make(map[requestKey]uint64),
+			inFlight: make(map[methodKey]int64),
+			durations: make(map[methodKey]*histogram),
+			requestSizes: make(map[methodKey]*histogram),
+			responseSizes: make(map[methodKey]*histogram),
+			pushes: make(map[methodKey]uint64),
+			pushSizes: make(map[methodKey]*histogram),
+		}
+	}
+
+	func observe(histograms map[methodKey]*histogram, buckets []float64, key methodKey, v float64) {
+		h, exists := histograms[key]
+		if !exists {
+			h = newHistogram(buckets)
+			histograms[key] = h
+		}
+		h.observe(v)
+	}
+
+	func (m *PrometheusMetrics) RequestStarted(service string, method string) {
+		m.mutex.Lock()
+		defer m.mutex.Unlock()
+		m.inFlight[methodKey{service, method}]++
+	}
+
+	func (m *PrometheusMetrics) RequestFinished(service string, method string, status string, duration time.Duration, requestBytes int, responseBytes int) {
+		m.mutex.Lock()
+		defer m.mutex.Unlock()
+		key := methodKey{service, method}
+		m.inFlight[key]--
+		m.requests[requestKey{key, status}]++
+		observe(m.durations, durationBuckets, key, duration.Seconds())
+		observe(m.requestSizes, sizeBuckets, key, float64(requestBytes))
+		observe(m.responseSizes, sizeBuckets, key, float64(responseBytes))
+	}
+
+	func (m *PrometheusMetrics) PushSent(service string, method string, bytes int) {
+		m.mutex.Lock()
+		defer m.mutex.Unlock()
+		key := methodKey{service, method}
+		m.pushes[key]++
+		observe(m.pushSizes, sizeBuckets, key, float64(bytes))
+	}
+
+	func (m *PrometheusMetrics) ConnectionOpened() {
+		m.mutex.Lock()
+		defer m.mutex.Unlock()
+		m.connections++
+	}
+
+	func (m *PrometheusMetrics) ConnectionClosed() {
+		m.mutex.Lock()
+		defer m.mutex.Unlock()
+		m.connections--
+	}
+
+	func sortedKeys[V any](values map[methodKey]V) []methodKey {
+		keys := make([]methodKey, 0, len(values))
+		for k := range values {
+			keys = append(keys, k)
+		}
+		sort.Slice(keys, func(i, j int) bool { return keys[i].less(keys[j]) })
+		return keys
+	}
+
+	func writeHistograms(out io.Writer, name string, help string, histograms map[methodKey]*histogram) {
+		fmt.Fprintf(out, "# HELP %s %s\n# TYPE %s histogram\n", name, help, name)
+		for _, key := range sortedKeys(histograms) {
+			histograms[key].write(out, name, key.labels())
+		}
+	}
+
+	// WriteText writes all metrics in the Prometheus text exposition format
+	func (m *PrometheusMetrics) WriteText(out io.Writer) {
+		m.mutex.Lock()
+		defer m.mutex.Unlock()
+
+		fmt.Fprintf(out, "# HELP wsrpc_requests_total Number of rpc calls by service, method and status.\n# TYPE wsrpc_requests_total counter\n")
+		keys := make([]requestKey, 0, len(m.requests))
+		for k := range m.requests {
+			keys = append(keys, k)
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			if keys[i].methodKey != keys[j].methodKey {
+				return keys[i].less(keys[j].methodKey)
+			}
+			return keys[i].status < keys[j].status
+		})
+		for _, k := range keys {
+			fmt.Fprintf(out, "wsrpc_requests_total{%sstatus=%q} %d\n", k.labels(), k.status, m.requests[k])
+		}
+
+		fmt.Fprintf(out, "# HELP wsrpc_requests_in_flight Number of rpc calls currently being handled.\n# TYPE wsrpc_requests_in_flight gauge\n")
+		for _, key := range sortedKeys(m.inFlight) {
+			fmt.Fprintf(out, "wsrpc_requests_in_flight{%s} %d\n", strings.TrimSuffix(key.labels(), ","), m.inFlight[key])
+		}
+
+		writeHistograms(out, "wsrpc_request_duration_seconds", "Duration of rpc calls.", m.durations)
+		writeHistograms(out, "wsrpc_request_bytes", "Size of rpc requests.", m.requestSizes)
+		writeHistograms(out, "wsrpc_response_bytes", "Size of rpc responses.", m.responseSizes)
+
+		fmt.Fprintf(out, "# HELP wsrpc_push_messages_total Number of push messages by service and method.\n# TYPE wsrpc_push_messages_total counter\n")
+		for _, key := range sortedKeys(m.pushes) {
+			fmt.Fprintf(out, "wsrpc_push_messages_total{%s} %d\n", strings.TrimSuffix(key.labels(), ","), m.pushes[key])
+		}
+		writeHistograms(out, "wsrpc_push_bytes", "Size of push messages.", m.pushSizes)
+
+		fmt.Fprintf(out, "# HELP wsrpc_connections_active Number of open connections.\n# TYPE wsrpc_connections_active gauge\n")
+		fmt.Fprintf(out, "wsrpc_connections_active %d\n", m.connections)
+	}
+
+	func (m *PrometheusMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		m.WriteText(w)
+	}`)
+
+	formattedCode, err := format.Source([]byte(sb.String()))
+	if err != nil {
+		return sb.String(), err
+	}
+	return string(formattedCode), nil
+}
@@ -0,0 +1,247 @@
+package generator
+
+import (
+	"go/format"
+	"strings"
+
+	"github.com/yoheimuta/go-protoparser/v4/interpret/unordered"
+)
+
+// generateGoRpcMock generates a mock per rpc service with a configurable function per method, recording all calls
+func generateGoRpcMock(pb *unordered.Proto, pkg string) (string, error) {
+	var sb strings.Builder
+	w := func(s string) { sb.WriteString(s + "\n") }
+
+	w("package " + pkg + "\n")
+	if hasServicesWithOption(pb, "(is_rpc)") {
+		w(`import (
+			"context"
+			"errors"
+			"sync"
+		)
+		`)
+	}
+	w(generatorWarning)
+
+	for _, srv := range pb.ProtoBody.Services {
+		if !hasServiceOption(srv, "(is_rpc)") {
+			continue
+		}
+		mock := srv.ServiceName + "Mock"
+
+		w("// " + mock + " implements " + srv.ServiceName + ". Calls are delegated to the <Method>Func fields and recorded.")
+		w("type " + mock + " struct {")
+		for _, rpc := range srv.ServiceBody.RPCs {
+			w(rpc.RPCName + "Func func" + strings.TrimPrefix(generateGoMethodSignature(rpc, true, true), rpc.RPCName))
+		}
+		w("")
+		w("mutex sync.Mutex")
+		for _, rpc := range srv.ServiceBody.RPCs {
+			if rpc.RPCRequest.MessageType != voidTypeName {
+				w(firstCharToLower(rpc.RPCName) + "Calls []*" + rpc.RPCRequest.MessageType)
+			} else {
+				w(firstCharToLower(rpc.RPCName) + "Calls int")
+			}
+		}
+		w("}\n")
+
+		for _, rpc := range srv.ServiceBody.RPCs {
+			calls := "m." + firstCharToLower(rpc.RPCName) + "Calls"
+			hasParam := rpc.RPCRequest.MessageType != voidTypeName
+			hasResponse := rpc.RPCResponse.MessageType != voidTypeName
+
+			w("func (m *" + mock + ") " + generateGoMethodSignature(rpc, true, true) + " {")
+			w("	m.mutex.Lock()")
+			if hasParam {
+				w("	" + calls + " = append(" + calls + ", param)")
+			} else {
+				w("	" + calls + "++")
+			}
+			w("	m.mutex.Unlock()")
+
+			w("	if m." + rpc.RPCName + "Func == nil {")
+			notConfigured := "errors.New(\"" + mock + "." + rpc.RPCName + " not configured\")"
+			if hasResponse {
+				w("		return nil, " + notConfigured)
+			} else {
+				w("		return " + notConfigured)
+			}
+			w("	}")
+			if hasParam {
+				w("	return m." + rpc.RPCName + "Func(ctx, param)")
+			} else {
+				w("	return m." + rpc.RPCName + "Func(ctx)")
+			}
+			w("}\n")
+
+			// Recorded calls
+			if hasParam {
+				w("// " + rpc.RPCName + "Calls returns the parameters of all calls to " + rpc.RPCName)
+				w("func (m *" + mock + ") " + rpc.RPCName + "Calls() []*" + rpc.RPCRequest.MessageType + " {")
+				w(`	m.mutex.Lock()
+					defer m.mutex.Unlock()
+					return append([]*` + rpc.RPCRequest.MessageType + `(nil), ` + calls + `...)
+				}
+				`)
+			} else {
+				w("// " + rpc.RPCName + "Calls returns the number of calls to " + rpc.RPCName)
+				w("func (m *" + mock + ") " + rpc.RPCName + `Calls() int {
+					m.mutex.Lock()
+					defer m.mutex.Unlock()
+					return ` + calls + `
+				}
+				`)
+			}
+		}
+
+		w("// Reset clears all recorded calls")
+		w("func (m *" + mock + ") Reset() {")
+		w("	m.mutex.Lock()")
+		w("	defer m.mutex.Unlock()")
+		for _, rpc := range srv.ServiceBody.RPCs {
+			if rpc.RPCRequest.MessageType != voidTypeName {
+				w("	m." + firstCharToLower(rpc.RPCName) + "Calls = nil")
+			} else {
+				w("	m." + firstCharToLower(rpc.RPCName) + "Calls = 0")
+			}
+		}
+		w("}\n")
+	}
+
+	formattedCode, err := format.Source([]byte(sb.String()))
+	if err != nil {
+		return sb.String(), err
+	}
+	return string(formattedCode), nil
+}
+
+// generateGoSspRecorder generates a push implementation per ssp service which records all messages in order
+func generateGoSspRecorder(pb *unordered.Proto, pkg string) (string, error) {
+	var sb strings.Builder
+	w := func(s string) { sb.WriteString(s + "\n") }
+
+	w("package " + pkg + "\n")
+	w(`import (
+		"strings"
+		"sync"
+
+		"google.golang.org/protobuf/proto"
+	)
+	`)
+	w(generatorWarning)
+
+	w(`// TestingT is the subset of testing.TB used by the assertion helpers
+	type TestingT interface {
+		Helper()
+		Errorf(format string, args ...any)
+	}
+
+	// PushMessage is a push message captured by a recorder
+	type PushMessage struct {
+		Name string
+		Message proto.Message
+	}
+
+	type pushRecorder struct {
+		mutex sync.Mutex
+		messages []PushMessage
+	}
+
+	// record stores a copy of the message, so later modifications don't change the recording
+	func (r *pushRecorder) record(name string, msg proto.Message) {
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+		r.messages = append(r.messages, PushMessage{Name: name, Message: proto.Clone(msg)})
+	}
+
+	// Messages returns all recorded messages in the order they were pushed
+	func (r *pushRecorder) Messages() []PushMessage {
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+		return append([]PushMessage(nil), r.messages...)
+	}
+
+	// Reset clears all recorded messages
+	func (r *pushRecorder) Reset() {
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+		r.messages = nil
+	}
+
+	func (r *pushRecorder) names() []string {
+		var names []string
+		for _, msg := range r.Messages() {
+			names = append(names, msg.Name)
+		}
+		return names
+	}
+
+	// AssertSequence checks that exactly the given push messages were recorded in this order
+	func (r *pushRecorder) AssertSequence(t TestingT, names ...string) bool {
+		t.Helper()
+		got := r.names()
+		if strings.Join(got, ",") != strings.Join(names, ",") {
+			t.Errorf("expected push messages [%s], got [%s]", strings.Join(names, ", "), strings.Join(got, ", "))
+			return false
+		}
+		return true
+	}
+
+	// AssertCount checks how often a push message was recorded
+	func (r *pushRecorder) AssertCount(t TestingT, name string, count int) bool {
+		t.Helper()
+		n := 0
+		for _, got := range r.names() {
+			if got == name {
+				n++
+			}
+		}
+		if n != count {
+			t.Errorf("expected %d push messages '%s', got %d", count, name, n)
+			return false
+		}
+		return true
+	}
+	`)
+
+	for _, srv := range pb.ProtoBody.Services {
+		if !hasServiceOption(srv, "(is_ssp)") {
+			continue
+		}
+		recorder := srv.ServiceName + "Recorder"
+
+		w("// " + recorder + " implements " + srv.ServiceName + " and records all push messages")
+		w("type " + recorder + ` struct {
+			pushRecorder
+		}
+		`)
+
+		for _, rpc := range srv.ServiceBody.RPCs {
+			msgType := rpc.RPCRequest.MessageType
+			w("func (r *" + recorder + ") " + rpc.RPCName + "(p0 *" + msgType + `) {
+				r.record("` + rpc.RPCName + `", p0)
+			}
+			`)
+
+			w("// " + rpc.RPCName + "Messages returns all recorded " + rpc.RPCName + " messages")
+			w("func (r *" + recorder + ") " + rpc.RPCName + "Messages() []*" + msgType + ` {
+				var result []*` + msgType + `
+				for _, msg := range r.Messages() {
+					if msg.Name == "` + rpc.RPCName + `" {
+						// a recorded nil message is returned as nil
+						p, _ := msg.Message.(*` + msgType + `)
+						result = append(result, p)
+					}
+				}
+				return result
+			}
+			`)
+		}
+	}
+
+	formattedCode, err := format.Source([]byte(sb.String()))
+	if err != nil {
+		return sb.String(), err
+	}
+	return string(formattedCode), nil
+}
@@ -0,0 +1,247 @@
+package generator
+
+import (
+	"fmt"
+	"go/format"
+	"strconv"
+	"strings"
+)
+
+func GenerateGoRateLimit(goBaseDir string, pkg string) error {
+	code, err := generateGoRateLimit(pkg)
+	if err != nil {
+		return fmt.Errorf("error generating go code: %v \n%s", err, code)
+	}
+
+	filename := fmt.Sprintf("%s/%s/%s.go", goBaseDir, pkg, "ratelimit_gen")
+	err = writeFile(filename, code)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// parseRateLimit parses the value of a (rate_limit) option like "10/s", "100/m" or "1000/h".
+// It returns the rate per second and the burst, which is the number of calls per interval.
+func parseRateLimit(value string) (rate float64, burst int, err error) {
+	count, unit, found := strings.Cut(value, "/")
+	if !found {
+		return 0, 0, fmt.Errorf("invalid rate limit '%s', expected e.g. '10/s'", value)
+	}
+	burst, err = strconv.Atoi(strings.TrimSpace(count))
+	if err != nil || burst <= 0 {
+		return 0, 0, fmt.Errorf("invalid rate limit '%s', expected a positive count", value)
+	}
+	switch strings.TrimSpace(unit) {
+	case "s":
+		rate = float64(burst)
+	case "m":
+		rate = float64(burst) / 60
+	case "h":
+		rate = float64(burst) / 3600
+	default:
+		return 0, 0, fmt.Errorf("invalid rate limit '%s', expected the unit s, m or h", value)
+	}
+	return rate, burst, nil
+}
+
+// generateGoRateLimit generates token bucket rate limiting per connection, identity and method
+func generateGoRateLimit(pkg string) (string, error) {
+	var sb strings.Builder
+	w := func(s string) { sb.WriteString(s + "\n") }
+
+	w("package " + pkg + "\n")
+	w(`import (
+		"errors"
+		"fmt"
+		"strconv"
+		"sync"
+		"time"
+	)
+	`)
+	w(generatorWarning)
+
+	w(`// RateLimit allows Burst calls at once, refilled with Rate calls per second
+	type RateLimit struct {
+		Rate float64
+		Burst int
+	}
+
+	func (l RateLimit) validate(name string) error {
+		if !(l.Rate > 0) || l.Burst < 1 {
+			return fmt.Errorf("invalid rate limit %s: rate %g and burst %d need to be positive", name, l.Rate, l.Burst)
+		}
+		return nil
+	}
+
+	// RateLimits configures a RateLimiter. Nil limits are unlimited.
+	type RateLimits struct {
+		// PerConnection limits all calls of a connection
+		PerConnection *RateLimit
+		// PerIdentity limits all calls of an authenticated identity across its connections
+		PerIdentity *RateLimit
+		// PerMethod limits the calls of a method per connection by method name. It overrides the (rate_limit) option.
+		PerMethod map[string]RateLimit
+	}
+
+	// RateLimiter rejects calls exceeding the limits with CodeResourceExhausted.
+	// Share one RateLimiter between all connections to limit identities.
+	type RateLimiter struct {
+		limits RateLimits
+		mutex sync.Mutex
+		identities map[string]*tokenBucket
+	}
+
+	// NewRateLimiter panics if a limit has no positive rate or burst
+	func NewRateLimiter(limits RateLimits) *RateLimiter {
+		if err := limits.validate(); err != nil {
+			panic(err)
+		}
+		return &RateLimiter{limits: limits, identities: make(map[string]*tokenBucket)}
+	}
+
+	func (l RateLimits) validate() error {
+		if l.PerConnection != nil {
+			if err := l.PerConnection.validate("PerConnection"); err != nil {
+				return err
+			}
+		}
+		if l.PerIdentity != nil {
+			if err := l.PerIdentity.validate("PerIdentity"); err != nil {
+				return err
+			}
+		}
+		for method, limit := range l.PerMethod {
+			if err := limit.validate("of '" + method + "'"); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	type tokenBucket struct {
+		tokens float64
+		updated time.Time
+	}
+
+	// refill adds the tokens accumulated since the last update
+	func (b *tokenBucket) refill(limit RateLimit, now time.Time) {
+		if b.updated.IsZero() {
+			b.tokens = float64(limit.Burst)
+		} else {
+			b.tokens = min(float64(limit.Burst), b.tokens+now.Sub(b.updated).Seconds()*limit.Rate)
+		}
+		b.updated = now
+	}
+
+	// wait returns the time until the bucket holds a token, 0 if it holds one
+	func (b *tokenBucket) wait(limit RateLimit) time.Duration {
+		if b.tokens >= 1 {
+			return 0
+		}
+		return time.Duration((1 - b.tokens) / limit.Rate * float64(time.Second))
+	}
+
+	func (b *tokenBucket) full(limit RateLimit, now time.Time) bool {
+		return b.tokens+now.Sub(b.updated).Seconds()*limit.Rate >= float64(limit.Burst)
+	}
+
+	// identityBucket returns the bucket of the identity and removes the buckets which are full again.
+	// The mutex needs to be locked.
+	func (l *RateLimiter) identityBucket(identity string, now time.Time) *tokenBucket {
+		b, exists := l.identities[identity]
+		if !exists {
+			for id, other := range l.identities {
+				if other.full(*l.limits.PerIdentity, now) {
+					delete(l.identities, id)
+				}
+			}
+			b = &tokenBucket{}
+			l.identities[identity] = b
+		}
+		return b
+	}
+
+	// connRateLimiter holds the buckets of a connection
+	type connRateLimiter struct {
+		limiter *RateLimiter
+		mutex sync.Mutex
+		conn tokenBucket
+		methods map[string]*tokenBucket
+	}
+
+	func (l *RateLimiter) newConnRateLimiter() *connRateLimiter {
+		return &connRateLimiter{limiter: l, methods: make(map[string]*tokenBucket)}
+	}
+
+	type limitedBucket struct {
+		bucket *tokenBucket
+		limit RateLimit
+	}
+
+	// allow takes a token from every bucket limiting the call. If one of them is empty, the call is rejected
+	// without taking any token, so rejected calls don't count against the other limits.
+	func (l *connRateLimiter) allow(identity string, authenticated bool, method MethodDescriptor) error {
+		now := time.Now()
+		limits := l.limiter.limits
+		var buckets []limitedBucket
+
+		// the buckets of the connection are always locked before the buckets of the identities
+		l.mutex.Lock()
+		defer l.mutex.Unlock()
+		if limits.PerConnection != nil {
+			buckets = append(buckets, limitedBucket{&l.conn, *limits.PerConnection})
+		}
+		methodLimit := method.RateLimit
+		if limit, exists := limits.PerMethod[method.Method]; exists {
+			methodLimit = &limit
+		}
+		if methodLimit != nil {
+			b, exists := l.methods[method.Method]
+			if !exists {
+				b = &tokenBucket{}
+				l.methods[method.Method] = b
+			}
+			buckets = append(buckets, limitedBucket{b, *methodLimit})
+		}
+		if limits.PerIdentity != nil && authenticated {
+			l.limiter.mutex.Lock()
+			defer l.limiter.mutex.Unlock()
+			buckets = append(buckets, limitedBucket{l.limiter.identityBucket(identity, now), *limits.PerIdentity})
+		}
+
+		var retryAfter time.Duration
+		for _, b := range buckets {
+			b.bucket.refill(b.limit, now)
+			retryAfter = max(retryAfter, b.bucket.wait(b.limit))
+		}
+		if retryAfter > 0 {
+			err := NewStatusError(CodeResourceExhausted, "rate limit of '%s' exceeded", method.Method)
+			err.Details = Metadata{"retry-after-ms": strconv.FormatInt(retryAfter.Milliseconds()+1, 10)}
+			return err
+		}
+		for _, b := range buckets {
+			b.bucket.tokens--
+		}
+		return nil
+	}
+
+	// RetryAfter returns how long to wait before retrying a call rejected by a rate limit
+	func RetryAfter(err error) (time.Duration, bool) {
+		var statusErr *StatusError
+		if !errors.As(err, &statusErr) {
+			return 0, false
+		}
+		ms, parseErr := strconv.ParseInt(statusErr.Details["retry-after-ms"], 10, 64)
+		if parseErr != nil {
+			return 0, false
+		}
+		return time.Duration(ms) * time.Millisecond, true
+	}`)
+
+	formattedCode, err := format.Source([]byte(sb.String()))
+	if err != nil {
+		return sb.String(), err
+	}
+	return string(formattedCode), nil
+}
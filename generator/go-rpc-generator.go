@@ -37,6 +37,17 @@ func GenerateGoRpcService(pbuf *parser.Proto, goBaseDir string, pkg string) erro
 	if err != nil {
 		return err
 	}
+
+	code, err = generateGoRpcMock(pb, pkg)
+	if err != nil {
+		return fmt.Errorf("error generating go code: %v \n%s", err, code)
+	}
+
+	filename = fmt.Sprintf("%s/%s/%s.go", goBaseDir, pkg, "rpc-mock_gen")
+	err = writeFile(filename, code)
+	if err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -45,13 +56,24 @@ func generateGoRpcInterface(pb *unordered.Proto, pkg string) (string, error) {
 	var sb strings.Builder
 	w := func(s string) { sb.WriteString(s + "\n") }
 	w("package " + pkg)
+	if hasServicesWithOption(pb, "(is_rpc)") {
+		w("import \"context\"")
+	}
+	w("")
+	w("// SchemaFingerprint identifies the services and methods the code was generated from")
+	w("const SchemaFingerprint = \"" + schemaFingerprint(pb) + "\"")
 
 	for _, srv := range pb.ProtoBody.Services {
 		if !hasServiceOption(srv, "(is_rpc)") {
 			continue
 		}
 
-		w(generateGoInterface(srv, srv.ServiceName, true))
+		w(generateGoInterface(srv, srv.ServiceName, true, true))
+		descriptors, err := generateGoMethodDescriptors(srv)
+		if err != nil {
+			return sb.String(), err
+		}
+		w(descriptors)
 	}
 
 	formattedCode, err := format.Source([]byte(sb.String()))
@@ -61,6 +83,50 @@ func generateGoRpcInterface(pb *unordered.Proto, pkg string) (string, error) {
 	return string(formattedCode), nil
 }
 
+// generateGoMethodDescriptors writes a MethodDescriptor per rpc with the options of the proto file
+func generateGoMethodDescriptors(srv *unordered.Service) (string, error) {
+	var sb strings.Builder
+	w := func(s string) { sb.WriteString(s + "\n") }
+
+	w("// " + srv.ServiceName + "Methods describes all methods of " + srv.ServiceName + " by name")
+	w("var " + srv.ServiceName + "Methods = map[string]MethodDescriptor{")
+	for _, rpc := range srv.ServiceBody.RPCs {
+		options, names := rpcOptions(rpc)
+
+		w(fmt.Sprintf("%q: {", rpc.RPCName))
+		w(fmt.Sprintf("Service: %q,", srv.ServiceName))
+		w(fmt.Sprintf("Method: %q,", rpc.RPCName))
+		if auth, exists := options["auth"]; exists {
+			w(fmt.Sprintf("Auth: %q,", auth))
+		}
+		if options["public"] == "true" {
+			w("Public: true,")
+		}
+		if d, err := rpcTimeout(rpc.RPCName, options); err != nil {
+			return "", err
+		} else if d > 0 {
+			w(fmt.Sprintf("Timeout: %d, // %s", d, d))
+		}
+		if limit, exists := options["rate_limit"]; exists {
+			rate, burst, err := parseRateLimit(limit)
+			if err != nil {
+				return "", fmt.Errorf("rpc %s: %v", rpc.RPCName, err)
+			}
+			w(fmt.Sprintf("RateLimit: &RateLimit{Rate: %g, Burst: %d},", rate, burst))
+		}
+		if len(options) > 0 {
+			w("Options: map[string]string{")
+			for _, name := range names {
+				w(fmt.Sprintf("%q: %q,", name, options[name]))
+			}
+			w("},")
+		}
+		w("},")
+	}
+	w("}")
+	return sb.String(), nil
+}
+
 // generateGoRpcHandler Generates go code to dispatch an incoming message,
 // call the corresponding handler function and manage all de-/serialization of parameters and responses
 func generateGoRpcHandler(pb *unordered.Proto, pkg string) (string, error) {
@@ -68,40 +134,67 @@ func generateGoRpcHandler(pb *unordered.Proto, pkg string) (string, error) {
 	w := func(s string) { sb.WriteString(s + "\n") }
 
 	w("package " + pkg + "\n")
-	w("import \"google.golang.org/protobuf/proto\"")
+	w(`import (
+		"context"
+
+		"google.golang.org/protobuf/proto"
+	)`)
 	w(generatorWarning)
 
-	w(`func sendAndReturnError(s WebSocket, requestId int, err error) error {
+	w(`func sendAndReturnError(ctx context.Context, s WebSocket, requestId int, err error) error {
 		errResponse := &` + errorTypeName + `{
 			Error: err.Error(),
 		}
 		errData, _ := proto.Marshal(errResponse)
-		responseId := intToByteArray(-requestId)
-		response := make([]byte, len(responseId) + len(errData))
-		copy(response, responseId)
-		copy(response[len(responseId):], errData)
-		s.WriteBinary(response)
+		s.WriteBinary(encodeResponse(-requestId, errorHeader(ctx, err), errData))
 		return err
 	}
+
+	type authorizedKey struct{}
+
+	// withAuthorized marks ctx as checked by the Authorizer of a Conn
+	func withAuthorized(ctx context.Context) context.Context {
+		return context.WithValue(ctx, authorizedKey{}, true)
+	}
+
+	// checkAuthorized denies methods with an (auth) option which were not checked by the Authorizer of a Conn
+	func checkAuthorized(ctx context.Context, method MethodDescriptor) error {
+		if method.Auth == "" || method.Public || ctx.Value(authorizedKey{}) != nil {
+			return nil
+		}
+		return NewStatusError(CodePermissionDenied, "'%s' requires authorization, serve it with a Conn", method.Method)
+	}
 	`)
 
 	for _, srv := range pb.ProtoBody.Services {
 		if !hasServiceOption(srv, "(is_rpc)") {
 			continue
 		}
-		w("func Handle" + srv.ServiceName + "Request(s WebSocket, handler " + srv.ServiceName + `, log Logger, inData []byte) error {
-			// get rpc function name
-			var name string
-			for i := 0; i < len(inData); i++ {
-				if inData[i] == 0 {
-					name = string(inData[0:i])
-					break
+		w("func Handle" + srv.ServiceName + "Request(ctx context.Context, s WebSocket, handler " + srv.ServiceName + `, log Logger, inData []byte) error {
+			// get rpc function name, metadata and request id
+			name, header, requestId, inData, err := parseRequest(inData)
+			if err != nil {
+				return err
+			}
+			ctx = newRequestContext(ctx, header)
+			// without a Conn the handshake succeeds without authentication, and calls can't be canceled
+			switch name {
+			case handshakeName:
+				SetResponseMetadata(ctx, "schema", SchemaFingerprint)
+				return s.WriteBinary(encodeResponse(requestId, responseHeader(ctx), nil))
+			case cancelName:
+				return nil
+			}
+			ctx, cancel := withRequestTimeout(ctx, header, ` + srv.ServiceName + `Methods[name].Timeout)
+			defer cancel()
+			if err := ctx.Err(); err != nil {
+				return sendAndReturnError(ctx, s, requestId, NewStatusError(CodeOf(err), "'%s' not handled: %v", name, err))
+			}
+			if method, exists := ` + srv.ServiceName + `Methods[name]; exists {
+				if err := checkAuthorized(ctx, method); err != nil {
+					return sendAndReturnError(ctx, s, requestId, err)
 				}
 			}
-			
-			// get request id
-			requestId := byteArrayToInt(inData[len(name):len(name)+4])
-			inData = inData[len(name)+4:]
 			var outData []byte
 
 			// dispatch function call
@@ -109,16 +202,15 @@ func generateGoRpcHandler(pb *unordered.Proto, pkg string) (string, error) {
 
 		for _, rpc := range srv.ServiceBody.RPCs {
 			w("	case \"" + rpc.RPCName + "\":")
-			w("	log.Log(\"Request: '" + rpc.RPCName + "'\")")
 
 			// De-Serialize input parameter
-			param := ""
+			param := "ctx"
 			if rpc.RPCRequest.MessageType != voidTypeName {
 				w("	prm := &" + rpc.RPCRequest.MessageType + "{}")
 				w(`	if err := proto.Unmarshal(inData, prm); err != nil {
-					return sendAndReturnError(s, requestId, err)
+					return sendAndReturnError(ctx, s, requestId, NewStatusError(CodeInvalidArgument, "invalid parameter: %v", err))
 				}`)
-				param = "prm"
+				param = "ctx, prm"
 			}
 
 			// Invoke handler
@@ -128,14 +220,14 @@ func generateGoRpcHandler(pb *unordered.Proto, pkg string) (string, error) {
 				w(fmt.Sprintf("	err := handler.%s(%s)", rpc.RPCName, param))
 			}
 			w(`	if err != nil {
-			return sendAndReturnError(s, requestId, err)
+			return sendAndReturnError(ctx, s, requestId, err)
 			}`)
 
 			// Serialize result data
 			if rpc.RPCResponse.MessageType != voidTypeName {
 				w(`	outData, err = proto.Marshal(resp)
 				if err != nil {
-				return sendAndReturnError(s, requestId, err)
+				return sendAndReturnError(ctx, s, requestId, NewStatusError(CodeInternal, "invalid response: %v", err))
 				}`)
 			}
 
@@ -144,17 +236,14 @@ func generateGoRpcHandler(pb *unordered.Proto, pkg string) (string, error) {
 
 		w(`	default:
 					log.Log("Invalid rpc call: \"" + name + "\"")
+					return sendAndReturnError(ctx, s, requestId, NewStatusError(CodeUnimplemented, "invalid rpc call: %s", name))
 				}`)
 
 		// Send response
 		w(`if len(outData) == 0 {
 			outData, _ = proto.Marshal(&` + voidTypeName + `{})
 		}
-		responseId := intToByteArray(requestId);
-		response := make([]byte, len(responseId) + len(outData))
-		copy(response, responseId)
-		copy(response[len(responseId):], outData)
-		s.WriteBinary(response)
+		s.WriteBinary(encodeResponse(requestId, responseHeader(ctx), outData))
 		`)
 
 		// No error
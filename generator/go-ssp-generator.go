@@ -26,17 +26,40 @@ func GenerateGoSspService(pbuf *parser.Proto, goBaseDir string, pkg string) erro
 	if err != nil {
 		return err
 	}
+
+	code, err = generateGoSspHub(pb, pkg)
+	if err != nil {
+		return fmt.Errorf("error generating go code: %v \n%s", err, code)
+	}
+
+	filename = fmt.Sprintf("%s/%s/%s.go", goBaseDir, pkg, "ssp-hub_gen")
+	err = writeFile(filename, code)
+	if err != nil {
+		return err
+	}
+
+	code, err = generateGoSspRecorder(pb, pkg)
+	if err != nil {
+		return fmt.Errorf("error generating go code: %v \n%s", err, code)
+	}
+
+	filename = fmt.Sprintf("%s/%s/%s.go", goBaseDir, pkg, "ssp-recorder_gen")
+	err = writeFile(filename, code)
+	if err != nil {
+		return err
+	}
 	return nil
 }
 
-// generateGoRpcHandler Generates go code to dispatch an incoming message,
-// call the corresponding handler function and manage all de-/serialization of parameters and responses
+// generateGoSspHandler generates the push interface and an implementation sending to a single WebSocket
 func generateGoSspHandler(pb *unordered.Proto, pkg string) (string, error) {
 	var sb strings.Builder
 	w := func(s string) { sb.WriteString(s + "\n") }
 
 	w("package " + pkg + "\n")
-	w("import \"google.golang.org/protobuf/proto\"")
+	if hasServicesWithOption(pb, "(is_ssp)") {
+		w("import \"google.golang.org/protobuf/proto\"")
+	}
 	w(generatorWarning)
 
 	for _, srv := range pb.ProtoBody.Services {
@@ -45,7 +68,7 @@ func generateGoSspHandler(pb *unordered.Proto, pkg string) (string, error) {
 		}
 
 		// Write interface
-		w(generateGoInterface(srv, srv.ServiceName, false))
+		w(generateGoInterface(srv, srv.ServiceName, false, false))
 
 		// Write data-struct and constructor
 		w("type " + srv.ServiceName + `Impl struct {
@@ -73,7 +96,7 @@ func generateGoSspHandler(pb *unordered.Proto, pkg string) (string, error) {
 					impl.log.Logf("Error in ` + srv.ServiceName + "." + rpc.RPCName + `: %v", err)
 					return
 				}
-				sendPushMessage(impl.ws, "` + rpc.RPCName + `", impl.log, data)
+				sendPushMessage(impl.ws, "` + srv.ServiceName + `", "` + rpc.RPCName + `", impl.log, data)
 			}`)
 		}
 	}
@@ -84,3 +107,160 @@ func generateGoSspHandler(pb *unordered.Proto, pkg string) (string, error) {
 	}
 	return string(formattedCode), nil
 }
+
+// generateGoSspHub generates a Hub tracking connections and topics
+// and a push implementation per service broadcasting to all members of the hub
+func generateGoSspHub(pb *unordered.Proto, pkg string) (string, error) {
+	var sb strings.Builder
+	w := func(s string) { sb.WriteString(s + "\n") }
+
+	w("package " + pkg + "\n")
+	w("import (")
+	w("	\"sync\"")
+	if hasServicesWithOption(pb, "(is_ssp)") {
+		w("	\"google.golang.org/protobuf/proto\"")
+	}
+	w(")\n")
+	w(generatorWarning)
+
+	w(`// Hub tracks connections and their topics to broadcast push messages
+	type Hub struct {
+		log Logger
+		mutex sync.RWMutex
+		members map[*Conn]map[string]struct{}
+	}
+
+	func NewHub(log Logger) *Hub {
+		if log == nil {
+			log = discardLogger{}
+		}
+		return &Hub{
+			log: log,
+			members: make(map[*Conn]map[string]struct{}),
+		}
+	}
+
+	// Add registers a connection for broadcasts. Closed connections are ignored.
+	func (h *Hub) Add(c *Conn) {
+		h.mutex.Lock()
+		defer h.mutex.Unlock()
+		h.register(c)
+	}
+
+	// register returns the topics of the connection, or nil if it is closed.
+	// The connection is checked under the lock, so it can't be registered again after Remove.
+	func (h *Hub) register(c *Conn) map[string]struct{} {
+		topics, exists := h.members[c]
+		if !exists {
+			if c.closed.Load() {
+				return nil
+			}
+			topics = make(map[string]struct{})
+			h.members[c] = topics
+		}
+		return topics
+	}
+
+	// Remove unregisters a connection and removes it from all topics
+	func (h *Hub) Remove(c *Conn) {
+		h.mutex.Lock()
+		defer h.mutex.Unlock()
+		delete(h.members, c)
+	}
+
+	// Join adds a connection to a topic. The connection is registered if necessary, closed connections are ignored.
+	func (h *Hub) Join(c *Conn, topic string) {
+		h.mutex.Lock()
+		defer h.mutex.Unlock()
+		if topics := h.register(c); topics != nil {
+			topics[topic] = struct{}{}
+		}
+	}
+
+	func (h *Hub) Leave(c *Conn, topic string) {
+		h.mutex.Lock()
+		defer h.mutex.Unlock()
+		if topics, exists := h.members[c]; exists {
+			delete(topics, topic)
+		}
+	}
+
+	// Count returns the number of connections subscribed to the topic or all connections for an empty topic
+	func (h *Hub) Count(topic string) int {
+		return len(h.collect(topic))
+	}
+
+	func (h *Hub) collect(topic string) []*Conn {
+		h.mutex.RLock()
+		defer h.mutex.RUnlock()
+		var result []*Conn
+		for c, topics := range h.members {
+			if topic != "" {
+				if _, exists := topics[topic]; !exists {
+					continue
+				}
+			}
+			result = append(result, c)
+		}
+		return result
+	}
+
+	// broadcast sends a push message to all connections subscribed to the topic or to all connections for an empty topic
+	func (h *Hub) broadcast(service string, name string, topic string, data []byte) {
+		receivers := h.collect(topic)
+		h.log.Logf("Broadcasting push message '%s' (%d bytes) to %d connections", name, len(data), len(receivers))
+		payload := pushFrame(name, data)
+		for _, c := range receivers {
+			if err := c.sendPush(service, name, payload); err != nil {
+				h.log.Logf("Error broadcasting '%s': %v", name, err)
+			}
+		}
+	}
+	`)
+
+	for _, srv := range pb.ProtoBody.Services {
+		if !hasServiceOption(srv, "(is_ssp)") {
+			continue
+		}
+
+		w("// " + srv.ServiceName + "Hub broadcasts all push messages of " + srv.ServiceName + " to the connections of a Hub")
+		w("type " + srv.ServiceName + `Hub struct {
+		hub *Hub
+		topic string
+		}
+		`)
+
+		w("func New" + srv.ServiceName + "Hub(hub *Hub) *" + srv.ServiceName + `Hub {
+		return &` + srv.ServiceName + `Hub{hub: hub}
+		}
+		`)
+
+		w("// Topic returns a " + srv.ServiceName + " sending only to the connections which joined the topic")
+		w("func (h *" + srv.ServiceName + "Hub) Topic(topic string) " + srv.ServiceName + ` {
+		return &` + srv.ServiceName + `Hub{hub: h.hub, topic: topic}
+		}
+		`)
+
+		for _, rpc := range srv.ServiceBody.RPCs {
+			for _, c := range rpc.Comments {
+				w(c.Raw)
+			}
+
+			w("func (h *" + srv.ServiceName + "Hub) " + rpc.RPCName + "(p0 *" + rpc.RPCRequest.MessageType + `) {
+				data, err := proto.Marshal(p0)
+				if err != nil {
+					h.hub.log.Logf("Error in ` + srv.ServiceName + "Hub." + rpc.RPCName + `: %v", err)
+					return
+				}
+				h.hub.broadcast("` + srv.ServiceName + `", "` + rpc.RPCName + `", h.topic, data)
+			}
+			`)
+		}
+	}
+
+	formattedCode, err := format.Source([]byte(sb.String()))
+	if err != nil {
+		return sb.String(), err
+	}
+	return string(formattedCode), nil
+}
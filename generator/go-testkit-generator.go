@@ -0,0 +1,175 @@
+package generator
+
+import (
+	"fmt"
+	"go/format"
+	"strings"
+)
+
+func GenerateGoTestKit(goBaseDir string, pkg string) error {
+	code, err := generateGoTestKit(pkg)
+	if err != nil {
+		return fmt.Errorf("error generating go code: %v \n%s", err, code)
+	}
+
+	filename := fmt.Sprintf("%s/%s/%s.go", goBaseDir, pkg, "testkit_gen")
+	err = writeFile(filename, code)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// generateGoTestKit generates an in-memory transport connecting a Conn and a Client without network
+func generateGoTestKit(pkg string) (string, error) {
+	var sb strings.Builder
+	w := func(s string) { sb.WriteString(s + "\n") }
+
+	w("package " + pkg + "\n")
+	w(`import (
+		"sync"
+	)
+	`)
+	w(generatorWarning)
+
+	w(`// MemorySocket is one end of an in-memory connection.
+	// It implements Socket and ClientSocket. Messages are delivered in order and writes never block.
+	type MemorySocket struct {
+		pipe *memoryPipe
+		inbox *memoryQueue
+		outbox *memoryQueue
+		mutex sync.Mutex
+		values map[string]interface{}
+	}
+
+	type memoryPipe struct {
+		closeOnce sync.Once
+		queues [2]*memoryQueue
+	}
+
+	type memoryQueue struct {
+		mutex sync.Mutex
+		cond *sync.Cond
+		messages [][]byte
+		closed bool
+	}
+
+	// NewMemoryPipe creates two connected sockets. Closing one end closes both.
+	func NewMemoryPipe() (server *MemorySocket, client *MemorySocket) {
+		pipe := &memoryPipe{}
+		for i := range pipe.queues {
+			q := &memoryQueue{}
+			q.cond = sync.NewCond(&q.mutex)
+			pipe.queues[i] = q
+		}
+		server = &MemorySocket{pipe: pipe, inbox: pipe.queues[0], outbox: pipe.queues[1], values: make(map[string]interface{})}
+		client = &MemorySocket{pipe: pipe, inbox: pipe.queues[1], outbox: pipe.queues[0], values: make(map[string]interface{})}
+		return server, client
+	}
+
+	func (q *memoryQueue) push(msg []byte) error {
+		q.mutex.Lock()
+		defer q.mutex.Unlock()
+		if q.closed {
+			return ErrConnClosed
+		}
+		q.messages = append(q.messages, append([]byte(nil), msg...))
+		q.cond.Signal()
+		return nil
+	}
+
+	func (q *memoryQueue) pop() ([]byte, error) {
+		q.mutex.Lock()
+		defer q.mutex.Unlock()
+		for len(q.messages) == 0 && !q.closed {
+			q.cond.Wait()
+		}
+		if len(q.messages) == 0 {
+			return nil, ErrConnClosed
+		}
+		msg := q.messages[0]
+		q.messages = q.messages[1:]
+		return msg, nil
+	}
+
+	func (q *memoryQueue) close() {
+		q.mutex.Lock()
+		defer q.mutex.Unlock()
+		q.closed = true
+		q.cond.Broadcast()
+	}
+
+	func (s *MemorySocket) Write(msg []byte) error {
+		return s.outbox.push(msg)
+	}
+
+	func (s *MemorySocket) WriteBinary(msg []byte) error {
+		return s.outbox.push(msg)
+	}
+
+	func (s *MemorySocket) Read() ([]byte, error) {
+		return s.inbox.pop()
+	}
+
+	func (s *MemorySocket) Close() error {
+		s.pipe.closeOnce.Do(func() {
+			for _, q := range s.pipe.queues {
+				q.close()
+			}
+		})
+		return nil
+	}
+
+	func (s *MemorySocket) Set(key string, value interface{}) {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		s.values[key] = value
+	}
+
+	func (s *MemorySocket) Get(key string) (value interface{}, exists bool) {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		value, exists = s.values[key]
+		return value, exists
+	}
+	`)
+
+	w(`// TestKit connects a Conn serving the configured handlers and a Client over a MemoryPipe
+	type TestKit struct {
+		Conn *Conn
+		Client *Client
+		served chan error
+		closeOnce sync.Once
+		err error
+	}
+
+	// NewTestKit creates the connection pair and starts serving
+	func NewTestKit(cfg *ConnConfig) *TestKit {
+		server, client := NewMemoryPipe()
+		k := &TestKit{
+			Conn: NewConn(server, cfg),
+			served: make(chan error, 1),
+		}
+		go func() {
+			k.served <- k.Conn.Serve()
+		}()
+		k.Client = NewClient(client, k.Conn.log)
+		return k
+	}
+
+	// Close closes the connection and waits until both sides have shut down
+	func (k *TestKit) Close() error {
+		k.closeOnce.Do(func() {
+			k.Conn.Close()
+			k.err = <-k.served
+			<-k.Client.Done()
+		})
+		return k.err
+	}`)
+
+	formattedCode, err := format.Source([]byte(sb.String()))
+	if err != nil {
+		return sb.String(), err
+	}
+	return string(formattedCode), nil
+}
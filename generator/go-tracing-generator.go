@@ -0,0 +1,232 @@
+package generator
+
+import (
+	"fmt"
+	"go/format"
+	"strings"
+)
+
+func GenerateGoTracing(goBaseDir string, pkg string) error {
+	code, err := generateGoTracing(pkg)
+	if err != nil {
+		return fmt.Errorf("error generating go code: %v \n%s", err, code)
+	}
+
+	filename := fmt.Sprintf("%s/%s/%s.go", goBaseDir, pkg, "tracing_gen")
+	err = writeFile(filename, code)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// generateGoTracing generates the Tracer interface, W3C trace context handling and an in-memory tracer.
+// An OpenTelemetry tracer can be bridged by implementing Tracer and Span.
+func generateGoTracing(pkg string) (string, error) {
+	var sb strings.Builder
+	w := func(s string) { sb.WriteString(s + "\n") }
+
+	w("package " + pkg + "\n")
+	w(`import (
+		"context"
+		"crypto/rand"
+		"encoding/hex"
+		"fmt"
+		"strings"
+		"sync"
+		"time"
+	)
+	`)
+	w(generatorWarning)
+
+	w(`// SpanContext identifies a span as defined by the W3C trace context
+	type SpanContext struct {
+		TraceID [16]byte
+		SpanID [8]byte
+		Sampled bool
+	}
+
+	func (sc SpanContext) IsValid() bool {
+		return sc.TraceID != [16]byte{} && sc.SpanID != [8]byte{}
+	}
+
+	// Traceparent returns the W3C traceparent header value
+	func (sc SpanContext) Traceparent() string {
+		flags := "00"
+		if sc.Sampled {
+			flags = "01"
+		}
+		return "00-" + hex.EncodeToString(sc.TraceID[:]) + "-" + hex.EncodeToString(sc.SpanID[:]) + "-" + flags
+	}
+
+	// ParseTraceparent parses a W3C traceparent header value
+	func ParseTraceparent(traceparent string) (SpanContext, error) {
+		var sc SpanContext
+		parts := strings.Split(traceparent, "-")
+		if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+			return sc, fmt.Errorf("invalid traceparent '%s'", traceparent)
+		}
+		traceId, err1 := hex.DecodeString(parts[1])
+		spanId, err2 := hex.DecodeString(parts[2])
+		flags, err3 := hex.DecodeString(parts[3])
+		if err1 != nil || err2 != nil || err3 != nil || len(traceId) != 16 || len(spanId) != 8 || len(flags) != 1 {
+			return sc, fmt.Errorf("invalid traceparent '%s'", traceparent)
+		}
+		copy(sc.TraceID[:], traceId)
+		copy(sc.SpanID[:], spanId)
+		sc.Sampled = flags[0]&1 == 1
+		if !sc.IsValid() {
+			return sc, fmt.Errorf("invalid traceparent '%s'", traceparent)
+		}
+		return sc, nil
+	}
+
+	// Span is a started span
+	type Span interface {
+		SpanContext() SpanContext
+		SetAttribute(key string, value any)
+		RecordError(err error)
+		End()
+	}
+
+	// Tracer starts spans for rpc calls and push messages.
+	// Implement it to forward the spans to OpenTelemetry.
+	type Tracer interface {
+		// Start starts a span. The remote parent is the span context received from the client and may be invalid.
+		// In this case the span in ctx (if any) is the parent.
+		Start(ctx context.Context, name string, remoteParent SpanContext) (context.Context, Span)
+	}
+
+	type spanContextKey struct{}
+
+	// ContextWithSpan returns a context carrying the span
+	func ContextWithSpan(ctx context.Context, span Span) context.Context {
+		return context.WithValue(ctx, spanContextKey{}, span)
+	}
+
+	// SpanFromContext returns the current span or nil
+	func SpanFromContext(ctx context.Context) Span {
+		span, _ := ctx.Value(spanContextKey{}).(Span)
+		return span
+	}
+
+	// startSpan starts a span with the tracer and stores it in the context. A nil tracer returns a nil span.
+	func startSpan(ctx context.Context, tracer Tracer, name string, remoteParent SpanContext) (context.Context, Span) {
+		if tracer == nil {
+			return ctx, nil
+		}
+		ctx, span := tracer.Start(ctx, name, remoteParent)
+		return ContextWithSpan(ctx, span), span
+	}
+	`)
+
+	w(`// RecordedSpan is a finished span captured by the InMemoryTracer
+	type RecordedSpan struct {
+		Name string
+		SpanContext SpanContext
+		Parent SpanContext
+		Attributes map[string]any
+		Err error
+		Start time.Time
+		End time.Time
+	}
+
+	// InMemoryTracer records all finished spans, e.g. to verify them in tests
+	type InMemoryTracer struct {
+		mutex sync.Mutex
+		spans []RecordedSpan
+	}
+
+	func NewInMemoryTracer() *InMemoryTracer {
+		return &InMemoryTracer{}
+	}
+
+	func (t *InMemoryTracer) Start(ctx context.Context, name string, remoteParent SpanContext) (context.Context, Span) {
+		parent := remoteParent
+		if !parent.IsValid() {
+			if span := SpanFromContext(ctx); span != nil {
+				parent = span.SpanContext()
+			}
+		}
+
+		sc := SpanContext{TraceID: parent.TraceID, Sampled: true}
+		if !parent.IsValid() {
+			rand.Read(sc.TraceID[:])
+		}
+		rand.Read(sc.SpanID[:])
+
+		span := &inMemorySpan{
+			tracer: t,
+			recorded: RecordedSpan{
+				Name: name,
+				SpanContext: sc,
+				Parent: parent,
+				Attributes: make(map[string]any),
+				Start: time.Now(),
+			},
+		}
+		return ctx, span
+	}
+
+	// Spans returns all finished spans in the order they ended
+	func (t *InMemoryTracer) Spans() []RecordedSpan {
+		t.mutex.Lock()
+		defer t.mutex.Unlock()
+		return append([]RecordedSpan(nil), t.spans...)
+	}
+
+	func (t *InMemoryTracer) Reset() {
+		t.mutex.Lock()
+		defer t.mutex.Unlock()
+		t.spans = nil
+	}
+
+	type inMemorySpan struct {
+		tracer *InMemoryTracer
+		mutex sync.Mutex
+		recorded RecordedSpan
+		ended bool
+	}
+
+	func (s *inMemorySpan) SpanContext() SpanContext {
+		return s.recorded.SpanContext
+	}
+
+	func (s *inMemorySpan) SetAttribute(key string, value any) {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		if !s.ended {
+			s.recorded.Attributes[key] = value
+		}
+	}
+
+	func (s *inMemorySpan) RecordError(err error) {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		if !s.ended {
+			s.recorded.Err = err
+		}
+	}
+
+	func (s *inMemorySpan) End() {
+		s.mutex.Lock()
+		if s.ended {
+			s.mutex.Unlock()
+			return
+		}
+		s.ended = true
+		s.recorded.End = time.Now()
+		recorded := s.recorded
+		s.mutex.Unlock()
+
+		s.tracer.mutex.Lock()
+		defer s.tracer.mutex.Unlock()
+		s.tracer.spans = append(s.tracer.spans, recorded)
+	}`)
+
+	formattedCode, err := format.Source([]byte(sb.String()))
+	if err != nil {
+		return sb.String(), err
+	}
+	return string(formattedCode), nil
+}
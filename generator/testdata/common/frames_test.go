@@ -0,0 +1,41 @@
+package api
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseRequestRejectsShortFrames(t *testing.T) {
+	for _, frame := range []string{"", "Ping", "Ping\x00", "Ping\x00\x00\x00", "\x00\x00\x00\x01"} {
+		if _, _, _, _, err := parseRequest([]byte(frame)); err == nil {
+			t.Errorf("frame %q: expected an error", frame)
+		}
+	}
+}
+
+func TestParseRequest(t *testing.T) {
+	frame := encodeRequest("Ping", map[string][]string{"key": {"value"}}, 7, []byte("data"))
+	name, header, requestId, data, err := parseRequest(frame)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "Ping" || header.Get("key") != "value" || requestId != 7 || string(data) != "data" {
+		t.Errorf("got %q %v %d %q", name, header, requestId, data)
+	}
+}
+
+func TestClientDropsInvalidFrames(t *testing.T) {
+	server, socket := NewMemoryPipe()
+	client := NewClient(socket, nil)
+	for _, frame := range []string{"", "?", "?key=value", "?%zz\x00\x00\x00\x01", "\x00\x00", "\x00\x00\x00\x07", "Push"} {
+		if err := server.WriteBinary([]byte(frame)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	server.Close()
+	select {
+	case <-client.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("client did not shut down")
+	}
+}
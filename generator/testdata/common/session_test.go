@@ -0,0 +1,12 @@
+package api
+
+import "testing"
+
+func TestSessionKeyNilInterface(t *testing.T) {
+	key := NewSessionKey[error]("err")
+	session := NewSession()
+	key.Set(session, nil)
+	if value, exists := key.Get(session); value != nil || !exists {
+		t.Errorf("got %v %v", value, exists)
+	}
+}
@@ -0,0 +1,75 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func newAuthTestService() *UserServiceMock {
+	return &UserServiceMock{
+		GetUserFunc: func(ctx context.Context, p *GetUserRequest) (*User, error) { return &User{Name: p.Name}, nil },
+		PingFunc:    func(ctx context.Context) error { return nil },
+		SetUserFunc: func(ctx context.Context, p *User) error { return nil },
+	}
+}
+
+func TestMethodDescriptors(t *testing.T) {
+	if m := UserServiceMethods["SetUser"]; m.Service != "UserService" || m.Method != "SetUser" || m.Auth != "admin" || m.Public {
+		t.Errorf("got %+v", m)
+	}
+	if m := UserServiceMethods["Ping"]; !m.Public || m.Auth != "" {
+		t.Errorf("got %+v", m)
+	}
+}
+
+func TestAuthorizationWithoutAuthorizer(t *testing.T) {
+	k := NewTestKit(&ConnConfig{UserService: newAuthTestService()})
+	defer k.Close()
+	ctx := context.Background()
+
+	if _, err := k.Client.UserService.GetUser(ctx, &GetUserRequest{}); err != nil {
+		t.Errorf("GetUser: %v", err)
+	}
+	if err := k.Client.UserService.SetUser(ctx, &User{}); CodeOf(err) != CodePermissionDenied {
+		t.Errorf("SetUser returned %v", err)
+	}
+}
+
+func TestAuthorizer(t *testing.T) {
+	var authorized []string
+	k := NewTestKit(&ConnConfig{
+		UserService: newAuthTestService(),
+		Authorizer: func(ctx context.Context, c *Conn, method MethodDescriptor) error {
+			authorized = append(authorized, method.Method)
+			switch {
+			case IncomingMetadata(ctx)["authorization"] == "":
+				return NewStatusError(CodeUnauthenticated, "not logged in")
+			case method.Auth != "":
+				return errors.New("no admin")
+			}
+			return nil
+		},
+	})
+	defer k.Close()
+	ctx := context.Background()
+	loggedIn := WithOutgoingMetadata(ctx, Metadata{"authorization": "token"})
+
+	if _, err := k.Client.UserService.GetUser(ctx, &GetUserRequest{}); CodeOf(err) != CodeUnauthenticated {
+		t.Errorf("GetUser without token returned %v", err)
+	}
+	if _, err := k.Client.UserService.GetUser(loggedIn, &GetUserRequest{}); err != nil {
+		t.Errorf("GetUser: %v", err)
+	}
+	// errors without a code are reported as permission denied
+	if err := k.Client.UserService.SetUser(loggedIn, &User{}); CodeOf(err) != CodePermissionDenied {
+		t.Errorf("SetUser returned %v", err)
+	}
+	// public methods bypass the authorizer
+	if err := k.Client.UserService.Ping(ctx); err != nil {
+		t.Errorf("Ping: %v", err)
+	}
+	if len(authorized) != 3 || authorized[2] != "SetUser" {
+		t.Errorf("authorizer called for %v", authorized)
+	}
+}
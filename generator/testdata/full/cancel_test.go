@@ -0,0 +1,99 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// waitForRequests waits until the connection has received n requests which are not finished
+func waitForRequests(t *testing.T, c *Conn, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		c.requestMutex.Lock()
+		count := len(c.requests)
+		c.requestMutex.Unlock()
+		if count == n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("connection has %d requests, expected %d", count, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestCancelRunningCall(t *testing.T) {
+	started := make(chan struct{})
+	canceled := make(chan struct{})
+	k := NewTestKit(&ConnConfig{UserService: &UserServiceMock{
+		PingFunc: func(ctx context.Context) error {
+			close(started)
+			<-ctx.Done()
+			close(canceled)
+			return ctx.Err()
+		},
+	}})
+	defer k.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error, 1)
+	go func() { result <- k.Client.UserService.Ping(ctx) }()
+	waitFor(t, started, "the handler to start")
+	cancel()
+	if err := <-result; !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v", err)
+	}
+	waitFor(t, canceled, "the handler to be canceled")
+}
+
+func TestCancelQueuedCall(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	mock := &UserServiceMock{
+		PingFunc: func(ctx context.Context) error {
+			close(started)
+			<-release
+			return nil
+		},
+		GetUserFunc: func(ctx context.Context, p *GetUserRequest) (*User, error) {
+			return &User{Name: p.Name}, nil
+		},
+	}
+	k := NewTestKit(&ConnConfig{UserService: mock})
+	defer k.Close()
+
+	pinged := make(chan error, 1)
+	go func() { pinged <- k.Client.UserService.Ping(context.Background()) }()
+	waitFor(t, started, "the first call to start")
+
+	// the second call waits behind the first one and is canceled before it runs
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error, 1)
+	go func() {
+		_, err := k.Client.UserService.GetUser(ctx, &GetUserRequest{Name: "ann"})
+		result <- err
+	}()
+	waitForRequests(t, k.Conn, 2)
+	cancel()
+	if err := <-result; !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v", err)
+	}
+	// the handshake is answered by the read loop, so the cancel frame sent before has been handled
+	if err := k.Client.Authenticate(context.Background(), nil); err != nil {
+		t.Fatal(err)
+	}
+	close(release)
+	if err := <-pinged; err != nil {
+		t.Fatal(err)
+	}
+	// a following call is answered after the canceled one was skipped
+	if _, err := k.Client.UserService.GetUser(context.Background(), &GetUserRequest{Name: "bob"}); err != nil {
+		t.Fatal(err)
+	}
+	if calls := mock.GetUserCalls(); len(calls) != 1 || calls[0].Name != "bob" {
+		t.Errorf("got calls %v", calls)
+	}
+}
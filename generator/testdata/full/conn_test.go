@@ -0,0 +1,112 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// closingUserService is a handler created per connection, which is closed with it
+type closingUserService struct {
+	UserServiceMock
+	closed chan struct{}
+}
+
+func (s *closingUserService) Close() error {
+	close(s.closed)
+	return nil
+}
+
+func waitFor(t *testing.T, ch <-chan struct{}, what string) {
+	t.Helper()
+	select {
+	case <-ch:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timeout waiting for %s", what)
+	}
+}
+
+func TestConnLifecycle(t *testing.T) {
+	handler := &closingUserService{closed: make(chan struct{})}
+	handler.GetUserFunc = func(ctx context.Context, p *GetUserRequest) (*User, error) {
+		return &User{Name: p.Name}, nil
+	}
+	connected := make(chan *Conn, 1)
+	disconnected := make(chan error, 1)
+	cfg := &ConnConfig{
+		NewUserService: func(c *Conn) UserService { return handler },
+		OnConnect: func(c *Conn) error {
+			connected <- c
+			return nil
+		},
+		OnDisconnect: func(c *Conn, err error) {
+			disconnected <- err
+		},
+	}
+	k := NewTestKit(cfg)
+
+	if c := <-connected; c != k.Conn {
+		t.Fatalf("OnConnect called with %v", c)
+	}
+	user, err := k.Client.UserService.GetUser(context.Background(), &GetUserRequest{Name: "ann"})
+	if err != nil || user.Name != "ann" {
+		t.Fatalf("got %v %v", user, err)
+	}
+
+	if err := k.Close(); err != nil {
+		t.Fatalf("Serve returned %v after Close", err)
+	}
+	if err := <-disconnected; err != nil {
+		t.Fatalf("OnDisconnect called with %v", err)
+	}
+	waitFor(t, handler.closed, "the handler to be closed")
+	if k.Conn.Context().Err() == nil {
+		t.Fatal("context of the connection not canceled")
+	}
+	if err := k.Conn.WriteBinary([]byte("x")); !errors.Is(err, ErrConnClosed) {
+		t.Fatalf("write after close returned %v", err)
+	}
+}
+
+func TestConnOnConnectError(t *testing.T) {
+	handler := &closingUserService{closed: make(chan struct{})}
+	rejected := errors.New("rejected")
+	cfg := &ConnConfig{
+		NewUserService: func(c *Conn) UserService { return handler },
+		OnConnect:      func(c *Conn) error { return rejected },
+		OnDisconnect: func(c *Conn, err error) {
+			t.Error("OnDisconnect called for a rejected connection")
+		},
+	}
+	server, client := NewMemoryPipe()
+	defer client.Close()
+	if err := NewConn(server, cfg).Serve(); !errors.Is(err, rejected) {
+		t.Fatalf("Serve returned %v", err)
+	}
+	waitFor(t, handler.closed, "the handler to be closed")
+	if _, err := client.Read(); !errors.Is(err, ErrConnClosed) {
+		t.Fatalf("socket not closed: %v", err)
+	}
+}
+
+func TestConnReadError(t *testing.T) {
+	disconnected := make(chan error, 1)
+	cfg := &ConnConfig{
+		UserService:  &UserServiceMock{},
+		OnDisconnect: func(c *Conn, err error) { disconnected <- err },
+	}
+	server, client := NewMemoryPipe()
+	served := make(chan error, 1)
+	go func() { served <- NewConn(server, cfg).Serve() }()
+
+	// closing the other end fails the read of the connection
+	client.Close()
+	err := <-served
+	if !errors.Is(err, ErrConnClosed) {
+		t.Fatalf("Serve returned %v", err)
+	}
+	if got := <-disconnected; !errors.Is(got, err) {
+		t.Fatalf("OnDisconnect called with %v", got)
+	}
+}
@@ -0,0 +1,79 @@
+package api
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDefaultTimeout(t *testing.T) {
+	k := NewTestKit(&ConnConfig{UserService: &UserServiceMock{
+		// GetUser has a (timeout) of 200ms
+		GetUserFunc: func(ctx context.Context, p *GetUserRequest) (*User, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		},
+	}})
+	defer k.Close()
+
+	start := time.Now()
+	_, err := k.Client.UserService.GetUser(context.Background(), &GetUserRequest{})
+	if CodeOf(err) != CodeDeadlineExceeded {
+		t.Fatalf("got %v", err)
+	}
+	if d := time.Since(start); d < 150*time.Millisecond {
+		t.Errorf("deadline exceeded after %v", d)
+	}
+}
+
+func TestClientDeadline(t *testing.T) {
+	deadlines := make(chan time.Time, 1)
+	k := NewTestKit(&ConnConfig{UserService: &UserServiceMock{
+		PingFunc: func(ctx context.Context) error {
+			deadline, _ := ctx.Deadline()
+			deadlines <- deadline
+			<-ctx.Done()
+			return ctx.Err()
+		},
+	}})
+	defer k.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	expected, _ := ctx.Deadline()
+	if err := k.Client.UserService.Ping(ctx); CodeOf(err) != CodeDeadlineExceeded {
+		t.Fatalf("got %v", err)
+	}
+	if deadline := <-deadlines; deadline.IsZero() || deadline.Sub(expected) > 50*time.Millisecond {
+		t.Errorf("handler deadline %v, client deadline %v", deadline, expected)
+	}
+}
+
+func TestHandleRequestWithDoneContext(t *testing.T) {
+	expired, cancel := context.WithTimeout(context.Background(), -time.Second)
+	defer cancel()
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	for code, ctx := range map[Code]context.Context{CodeDeadlineExceeded: expired, CodeCanceled: canceled} {
+		t.Run(string(code), func(t *testing.T) {
+			server, client := NewMemoryPipe()
+			defer client.Close()
+			mock := &UserServiceMock{}
+			frame := encodeRequest("Ping", nil, 1, nil)
+			if err := HandleUserServiceRequest(ctx, server, mock, discardLogger{}, frame); CodeOf(err) != code {
+				t.Errorf("Handle returned %v", err)
+			}
+			response, err := client.Read()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(string(response), "code="+string(code)) {
+				t.Errorf("got response %q", response)
+			}
+			if mock.PingCalls() != 0 {
+				t.Error("handler called")
+			}
+		})
+	}
+}
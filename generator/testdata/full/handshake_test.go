@@ -0,0 +1,58 @@
+package api
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestHandshake(t *testing.T) {
+	hub := NewHub(nil)
+	k := NewTestKit(&ConnConfig{
+		UserService: newAuthTestService(),
+		Hub:         hub,
+		Authenticate: func(ctx context.Context, c *Conn, credentials Metadata) (string, error) {
+			if credentials["token"] != "secret" {
+				return "", NewStatusError(CodeUnauthenticated, "invalid token")
+			}
+			return "ann", nil
+		},
+	})
+	defer k.Close()
+	received := make(chan *User, 10)
+	k.Client.Events.OnUserUpdated(func(p *User) { received <- p })
+	ctx := context.Background()
+	events := NewEventsHub(hub)
+	waitForCount(t, hub, "", 1)
+
+	// before the handshake calls fail, even to public methods, and push messages are dropped
+	if err := k.Client.UserService.Ping(ctx); CodeOf(err) != CodeUnauthenticated {
+		t.Errorf("Ping before the handshake returned %v", err)
+	}
+	events.UserUpdated(&User{Name: "dropped"})
+	if err := k.Client.Authenticate(ctx, Metadata{"token": "wrong"}); CodeOf(err) != CodeUnauthenticated {
+		t.Errorf("handshake with invalid credentials returned %v", err)
+	}
+	if _, authenticated := k.Conn.Identity(); authenticated {
+		t.Fatal("connection authenticated with invalid credentials")
+	}
+
+	if err := k.Client.Authenticate(ctx, Metadata{"token": "secret"}); err != nil {
+		t.Fatal(err)
+	}
+	if identity, authenticated := k.Conn.Identity(); !authenticated || identity != "ann" {
+		t.Fatalf("got identity %q %v", identity, authenticated)
+	}
+	if err := k.Client.UserService.Ping(ctx); err != nil {
+		t.Errorf("Ping after the handshake: %v", err)
+	}
+	events.UserUpdated(&User{Name: "sent"})
+	if user := receiveUser(t, received); user.Name != "sent" {
+		t.Errorf("got %v", user)
+	}
+	select {
+	case user := <-received:
+		t.Errorf("received %v", user)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
@@ -0,0 +1,101 @@
+package api
+
+import (
+	"testing"
+	"time"
+)
+
+// waitForCount waits until the hub has registered n connections for the topic
+func waitForCount(t *testing.T, hub *Hub, topic string, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for hub.Count(topic) != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("hub has %d connections for topic %q, expected %d", hub.Count(topic), topic, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func receiveUser(t *testing.T, ch <-chan *User) *User {
+	t.Helper()
+	select {
+	case user := <-ch:
+		return user
+	case <-time.After(5 * time.Second):
+		t.Fatal("push message not received")
+		return nil
+	}
+}
+
+func TestHubBroadcast(t *testing.T) {
+	hub := NewHub(nil)
+	var received [2]chan *User
+	var kits [2]*TestKit
+	for i := range kits {
+		kits[i] = NewTestKit(&ConnConfig{Hub: hub})
+		defer kits[i].Close()
+		ch := make(chan *User, 10)
+		kits[i].Client.Events.OnUserUpdated(func(p *User) { ch <- p })
+		received[i] = ch
+	}
+	waitForCount(t, hub, "", 2)
+	hub.Join(kits[1].Conn, "room")
+
+	events := NewEventsHub(hub)
+	events.UserUpdated(&User{Name: "all"})
+	events.Topic("room").UserUpdated(&User{Name: "room"})
+
+	if user := receiveUser(t, received[0]); user.Name != "all" {
+		t.Fatalf("got %v", user)
+	}
+	for _, name := range []string{"all", "room"} {
+		if user := receiveUser(t, received[1]); user.Name != name {
+			t.Fatalf("got %v, expected %s", user, name)
+		}
+	}
+	select {
+	case user := <-received[0]:
+		t.Fatalf("message of the topic received outside of it: %v", user)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	hub.Leave(kits[1].Conn, "room")
+	if n := hub.Count("room"); n != 0 {
+		t.Fatalf("%d connections in the topic after Leave", n)
+	}
+}
+
+func TestHubRemovesClosedConn(t *testing.T) {
+	hub := NewHub(nil)
+	k := NewTestKit(&ConnConfig{Hub: hub})
+	waitForCount(t, hub, "", 1)
+	k.Close()
+	if n := hub.Count(""); n != 0 {
+		t.Fatalf("%d connections after close", n)
+	}
+
+	hub.Join(k.Conn, "room")
+	hub.Add(k.Conn)
+	if n := hub.Count(""); n != 0 {
+		t.Fatalf("closed connection registered again")
+	}
+	NewEventsHub(hub).UserUpdated(&User{})
+}
+
+func TestHubRemovesRejectedConn(t *testing.T) {
+	hub := NewHub(nil)
+	cfg := &ConnConfig{
+		Hub: hub,
+		OnConnect: func(c *Conn) error {
+			hub.Join(c, "room")
+			return ErrConnClosed
+		},
+	}
+	server, client := NewMemoryPipe()
+	defer client.Close()
+	NewConn(server, cfg).Serve()
+	if n := hub.Count(""); n != 0 {
+		t.Fatalf("%d connections after a rejected connect", n)
+	}
+}
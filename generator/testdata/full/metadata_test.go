@@ -0,0 +1,41 @@
+package api
+
+import (
+	"context"
+	"testing"
+)
+
+func TestMetadata(t *testing.T) {
+	k := NewTestKit(&ConnConfig{
+		UserService: &UserServiceMock{
+			GetUserFunc: func(ctx context.Context, p *GetUserRequest) (*User, error) {
+				SetResponseMetadata(ctx, "server-version", "1.2")
+				return &User{Name: IncomingMetadata(ctx)["authorization"]}, nil
+			},
+		},
+	})
+	defer k.Close()
+
+	md := Metadata{}
+	ctx := WithOutgoingMetadata(context.Background(), Metadata{"authorization": "token"})
+	ctx = CaptureResponseMetadata(ctx, md)
+	user, err := k.Client.UserService.GetUser(ctx, &GetUserRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Name != "token" {
+		t.Errorf("request metadata not received: %v", user)
+	}
+	if md["server-version"] != "1.2" {
+		t.Errorf("got response metadata %v", md)
+	}
+}
+
+func TestMetadataWithoutRequest(t *testing.T) {
+	// outside of a request the metadata is empty and response metadata is dropped
+	ctx := context.Background()
+	SetResponseMetadata(ctx, "key", "value")
+	if md := IncomingMetadata(ctx); len(md) != 0 {
+		t.Fatalf("got %v", md)
+	}
+}
@@ -0,0 +1,57 @@
+package api
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestPrometheusMetrics(t *testing.T) {
+	metrics := NewPrometheusMetrics()
+	hub := NewHub(nil)
+	k := NewTestKit(&ConnConfig{
+		UserService: &UserServiceMock{
+			GetUserFunc: func(ctx context.Context, p *GetUserRequest) (*User, error) {
+				return &User{Name: p.Name}, nil
+			},
+		},
+		Metrics: metrics,
+		Hub:     hub,
+	})
+	received := make(chan *User, 1)
+	k.Client.Events.OnUserUpdated(func(p *User) { received <- p })
+
+	if _, err := k.Client.UserService.GetUser(context.Background(), &GetUserRequest{Name: "ann"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := k.Client.UserService.Ping(context.Background()); err == nil {
+		t.Fatal("unconfigured method succeeded")
+	}
+	waitForCount(t, hub, "", 1)
+	NewEventsHub(hub).UserUpdated(&User{Name: "ann"})
+	receiveUser(t, received)
+
+	var open strings.Builder
+	metrics.WriteText(&open)
+	if !strings.Contains(open.String(), "wsrpc_connections_active 1\n") {
+		t.Errorf("open connection not counted:\n%s", open.String())
+	}
+
+	// the requests are recorded when their handling finished, which Close waits for
+	k.Close()
+	var out strings.Builder
+	metrics.WriteText(&out)
+	for _, line := range []string{
+		`wsrpc_requests_total{service="UserService",method="GetUser",status="ok"} 1`,
+		`wsrpc_requests_total{service="UserService",method="Ping",status="unknown"} 1`,
+		`wsrpc_requests_in_flight{service="UserService",method="GetUser"} 0`,
+		`wsrpc_request_duration_seconds_count{service="UserService",method="GetUser"} 1`,
+		`wsrpc_push_messages_total{service="Events",method="UserUpdated"} 1`,
+		`wsrpc_push_bytes_count{service="Events",method="UserUpdated"} 1`,
+		`wsrpc_connections_active 0`,
+	} {
+		if !strings.Contains(out.String(), line+"\n") {
+			t.Errorf("missing %s in:\n%s", line, out.String())
+		}
+	}
+}
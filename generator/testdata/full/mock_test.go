@@ -0,0 +1,75 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+func TestMockRecordsCalls(t *testing.T) {
+	mock := &UserServiceMock{
+		GetUserFunc: func(ctx context.Context, p *GetUserRequest) (*User, error) {
+			return &User{Name: p.Name}, nil
+		},
+	}
+	k := NewTestKit(&ConnConfig{UserService: mock})
+	defer k.Close()
+
+	if _, err := k.Client.UserService.GetUser(context.Background(), &GetUserRequest{Name: "ann"}); err != nil {
+		t.Fatal(err)
+	}
+	if calls := mock.GetUserCalls(); len(calls) != 1 || calls[0].Name != "ann" {
+		t.Fatalf("got %v", calls)
+	}
+	// methods without a function fail
+	if err := k.Client.UserService.Ping(context.Background()); err == nil {
+		t.Fatal("unconfigured method succeeded")
+	}
+	if n := mock.PingCalls(); n != 1 {
+		t.Fatalf("got %d calls", n)
+	}
+}
+
+// recordingT records the errors of the assertion helpers
+type recordingT struct {
+	errors []string
+}
+
+func (t *recordingT) Helper() {}
+
+func (t *recordingT) Errorf(format string, args ...any) {
+	t.errors = append(t.errors, fmt.Sprintf(format, args...))
+}
+
+func TestRecorder(t *testing.T) {
+	recorder := &EventsRecorder{}
+	user := &User{Name: "ann"}
+	recorder.UserUpdated(user)
+	user.Name = "changed"
+	recorder.ChatMessage(&Chat{Text: "hi"})
+	recorder.UserUpdated(nil)
+	recorder.record("UserUpdated", nil)
+
+	users := recorder.UserUpdatedMessages()
+	if len(users) != 3 || users[0].Name != "ann" || users[1] != nil || users[2] != nil {
+		t.Fatalf("got %v", users)
+	}
+	if !recorder.AssertSequence(t, "UserUpdated", "ChatMessage", "UserUpdated", "UserUpdated") {
+		return
+	}
+	if !recorder.AssertCount(t, "ChatMessage", 1) {
+		return
+	}
+
+	failed := &recordingT{}
+	recorder.AssertSequence(failed, "ChatMessage")
+	recorder.AssertCount(failed, "UserUpdated", 1)
+	if len(failed.errors) != 2 {
+		t.Fatalf("got %v", failed.errors)
+	}
+
+	recorder.Reset()
+	if n := len(recorder.Messages()); n != 0 {
+		t.Fatalf("%d messages after Reset", n)
+	}
+}
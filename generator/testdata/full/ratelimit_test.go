@@ -0,0 +1,103 @@
+package api
+
+import (
+	"context"
+	"testing"
+)
+
+func newLimitedService() *UserServiceMock {
+	service := newAuthTestService()
+	service.LimitedFunc = func(ctx context.Context) (*User, error) { return &User{}, nil }
+	return service
+}
+
+func TestRateLimitOption(t *testing.T) {
+	k := NewTestKit(&ConnConfig{UserService: newLimitedService()})
+	defer k.Close()
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		if _, err := k.Client.UserService.Limited(ctx); err != nil {
+			t.Fatalf("call %d: %v", i, err)
+		}
+	}
+	_, err := k.Client.UserService.Limited(ctx)
+	if CodeOf(err) != CodeResourceExhausted {
+		t.Fatalf("got %v", err)
+	}
+	if retryAfter, ok := RetryAfter(err); !ok || retryAfter <= 0 {
+		t.Errorf("got retry after %v %v", retryAfter, ok)
+	}
+	// other methods are not limited
+	if err := k.Client.UserService.Ping(ctx); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRateLimitTakesNoTokenOfRejectedCalls(t *testing.T) {
+	k := NewTestKit(&ConnConfig{
+		UserService: newLimitedService(),
+		RateLimiter: NewRateLimiter(RateLimits{PerConnection: &RateLimit{Rate: 0.001, Burst: 4}}),
+	})
+	defer k.Close()
+	ctx := context.Background()
+
+	// the third call is rejected by the limit of the method and leaves a token of the connection
+	for i := 0; i < 3; i++ {
+		k.Client.UserService.Limited(ctx)
+	}
+	for i := 0; i < 2; i++ {
+		if err := k.Client.UserService.Ping(ctx); err != nil {
+			t.Fatalf("call %d: %v", i, err)
+		}
+	}
+	if err := k.Client.UserService.Ping(ctx); CodeOf(err) != CodeResourceExhausted {
+		t.Fatalf("got %v", err)
+	}
+}
+
+func TestRateLimitAfterAuthentication(t *testing.T) {
+	k := NewTestKit(&ConnConfig{
+		UserService: newLimitedService(),
+		RateLimiter: NewRateLimiter(RateLimits{
+			PerConnection: &RateLimit{Rate: 0.001, Burst: 1},
+			PerIdentity:   &RateLimit{Rate: 0.001, Burst: 1},
+		}),
+		Authenticate: func(ctx context.Context, c *Conn, credentials Metadata) (string, error) { return "ann", nil },
+	})
+	defer k.Close()
+	ctx := context.Background()
+
+	// calls before the handshake take no token
+	for i := 0; i < 2; i++ {
+		if err := k.Client.UserService.Ping(ctx); CodeOf(err) != CodeUnauthenticated {
+			t.Fatalf("got %v", err)
+		}
+	}
+	if err := k.Client.Authenticate(ctx, Metadata{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := k.Client.UserService.Ping(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := k.Client.UserService.Ping(ctx); CodeOf(err) != CodeResourceExhausted {
+		t.Fatalf("got %v", err)
+	}
+}
+
+func TestNewRateLimiterValidatesLimits(t *testing.T) {
+	for name, limits := range map[string]RateLimits{
+		"rate":   {PerConnection: &RateLimit{Burst: 1}},
+		"burst":  {PerIdentity: &RateLimit{Rate: 1}},
+		"method": {PerMethod: map[string]RateLimit{"Ping": {Rate: -1, Burst: 1}}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("accepted %+v", limits)
+				}
+			}()
+			NewRateLimiter(limits)
+		})
+	}
+}
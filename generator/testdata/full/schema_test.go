@@ -0,0 +1,53 @@
+package api
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// sendHandshake sends a handshake with the schema fingerprint and returns the response frame
+func sendHandshake(t *testing.T, cfg *ConnConfig, fingerprint string) string {
+	t.Helper()
+	server, client := NewMemoryPipe()
+	defer client.Close()
+	go NewConn(server, cfg).Serve()
+	if err := client.WriteBinary(encodeRequest(handshakeName, url.Values{"schema": {fingerprint}}, 1, nil)); err != nil {
+		t.Fatal(err)
+	}
+	response, err := client.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(response)
+}
+
+func TestSchemaMismatch(t *testing.T) {
+	var mismatches []string
+	cfg := &ConnConfig{OnSchemaMismatch: func(c *Conn, clientFingerprint string) error {
+		mismatches = append(mismatches, clientFingerprint)
+		return errors.New("client outdated")
+	}}
+
+	if response := sendHandshake(t, cfg, SchemaFingerprint); strings.Contains(response, "code=") {
+		t.Errorf("handshake with the same schema failed: %q", response)
+	}
+	if len(mismatches) != 0 {
+		t.Errorf("mismatch reported for %v", mismatches)
+	}
+
+	response := sendHandshake(t, cfg, "other")
+	if !strings.Contains(response, "code="+string(CodeFailedPrecondition)) || !strings.Contains(response, "schema="+SchemaFingerprint) {
+		t.Errorf("got %q", response)
+	}
+	if len(mismatches) != 1 || mismatches[0] != "other" {
+		t.Errorf("got mismatches %v", mismatches)
+	}
+}
+
+func TestSchemaMismatchWithoutHook(t *testing.T) {
+	if response := sendHandshake(t, &ConnConfig{}, "other"); strings.Contains(response, "code=") {
+		t.Errorf("handshake failed: %q", response)
+	}
+}
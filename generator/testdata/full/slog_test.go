@@ -0,0 +1,93 @@
+package api
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"testing"
+)
+
+type logRecord struct {
+	level slog.Level
+	msg   string
+	attrs map[string]any
+}
+
+// recordingLogger is a StructuredLogger keeping all records
+type recordingLogger struct {
+	mutex   sync.Mutex
+	records []logRecord
+}
+
+func (l *recordingLogger) LogAttrs(ctx context.Context, level slog.Level, msg string, attrs ...slog.Attr) {
+	record := logRecord{level: level, msg: msg, attrs: make(map[string]any)}
+	for _, attr := range attrs {
+		record.attrs[attr.Key] = attr.Value.Any()
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.records = append(l.records, record)
+}
+
+func (l *recordingLogger) find(msg string) (logRecord, bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	for _, record := range l.records {
+		if record.msg == msg {
+			return record, true
+		}
+	}
+	return logRecord{}, false
+}
+
+func TestStructuredLogging(t *testing.T) {
+	log := &recordingLogger{}
+	hub := NewHub(nil)
+	k := NewTestKit(&ConnConfig{
+		UserService: &UserServiceMock{
+			GetUserFunc: func(ctx context.Context, p *GetUserRequest) (*User, error) {
+				return &User{Name: p.Name}, nil
+			},
+		},
+		Slog:      log,
+		LogLevels: &LogLevels{Request: slog.LevelDebug, Response: slog.LevelInfo, Push: slog.LevelDebug, Failure: slog.LevelWarn},
+		Hub:       hub,
+	})
+	received := make(chan *User, 1)
+	k.Client.Events.OnUserUpdated(func(p *User) { received <- p })
+
+	if _, err := k.Client.UserService.GetUser(context.Background(), &GetUserRequest{Name: "ann"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := k.Client.UserService.Ping(context.Background()); err == nil {
+		t.Fatal("unconfigured method succeeded")
+	}
+	waitForCount(t, hub, "", 1)
+	NewEventsHub(hub).UserUpdated(&User{Name: "ann"})
+	receiveUser(t, received)
+	k.Close()
+
+	for _, expected := range []logRecord{
+		{slog.LevelDebug, "rpc request", map[string]any{"service": "UserService", "method": "GetUser"}},
+		{slog.LevelInfo, "rpc response", map[string]any{"service": "UserService", "method": "GetUser", "code": "ok"}},
+		{slog.LevelWarn, "rpc failed", map[string]any{"service": "UserService", "method": "Ping", "code": "unknown"}},
+		{slog.LevelDebug, "push", map[string]any{"service": "Events", "method": "UserUpdated"}},
+	} {
+		record, exists := log.find(expected.msg)
+		if !exists {
+			t.Errorf("no record '%s' in %v", expected.msg, log.records)
+			continue
+		}
+		if record.level != expected.level {
+			t.Errorf("'%s' logged at %v", expected.msg, record.level)
+		}
+		for key, value := range expected.attrs {
+			if record.attrs[key] != value {
+				t.Errorf("'%s' has %s=%v, expected %v", expected.msg, key, record.attrs[key], value)
+			}
+		}
+		if _, exists := record.attrs["conn"]; !exists {
+			t.Errorf("'%s' without conn", expected.msg)
+		}
+	}
+}
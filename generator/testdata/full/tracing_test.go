@@ -0,0 +1,90 @@
+package api
+
+import (
+	"context"
+	"testing"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	traceparent := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	sc, err := ParseTraceparent(traceparent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sc.Sampled || sc.Traceparent() != traceparent {
+		t.Fatalf("got %+v", sc)
+	}
+	for _, invalid := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-xyz067aa0ba902b7-01",
+	} {
+		if _, err := ParseTraceparent(invalid); err == nil {
+			t.Errorf("accepted %q", invalid)
+		}
+	}
+	// later versions may append fields
+	if _, err := ParseTraceparent("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestTracing(t *testing.T) {
+	tracer := NewInMemoryTracer()
+	hub := NewHub(nil)
+	k := NewTestKit(&ConnConfig{
+		UserService: &UserServiceMock{
+			GetUserFunc: func(ctx context.Context, p *GetUserRequest) (*User, error) {
+				if SpanFromContext(ctx) == nil {
+					t.Error("no span in the context of the handler")
+				}
+				return &User{Name: p.Name}, nil
+			},
+		},
+		Tracer: tracer,
+		Hub:    hub,
+	})
+	received := make(chan *User, 1)
+	k.Client.Events.OnUserUpdated(func(p *User) { received <- p })
+
+	// the client span is the parent of the server span
+	clientTracer := NewInMemoryTracer()
+	ctx, clientSpan := clientTracer.Start(context.Background(), "client", SpanContext{})
+	ctx = ContextWithSpan(ctx, clientSpan)
+	if _, err := k.Client.UserService.GetUser(ctx, &GetUserRequest{Name: "ann"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := k.Client.UserService.Ping(context.Background()); err == nil {
+		t.Fatal("unconfigured method succeeded")
+	}
+	waitForCount(t, hub, "", 1)
+	NewEventsHub(hub).UserUpdated(&User{Name: "ann"})
+	receiveUser(t, received)
+	k.Close()
+
+	spans := make(map[string]RecordedSpan)
+	for _, span := range tracer.Spans() {
+		spans[span.Name] = span
+	}
+	getUser, exists := spans["UserService/GetUser"]
+	if !exists {
+		t.Fatalf("no span for GetUser in %v", tracer.Spans())
+	}
+	if getUser.Parent != clientSpan.SpanContext() || getUser.SpanContext.TraceID != clientSpan.SpanContext().TraceID {
+		t.Errorf("span not continuing the client trace: %+v", getUser)
+	}
+	if getUser.Attributes["rpc.status"] != "ok" || getUser.Attributes["rpc.method"] != "GetUser" || getUser.Err != nil {
+		t.Errorf("got %+v", getUser)
+	}
+
+	ping := spans["UserService/Ping"]
+	if ping.Err == nil || ping.Attributes["rpc.status"] != "unknown" || ping.Parent.IsValid() {
+		t.Errorf("got %+v", ping)
+	}
+	if _, exists := spans["push UserUpdated"]; !exists {
+		t.Errorf("no span for the push message in %v", tracer.Spans())
+	}
+}
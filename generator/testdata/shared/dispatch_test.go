@@ -0,0 +1,49 @@
+package api
+
+import (
+	"context"
+	"testing"
+)
+
+func TestSharedNameDispatchedToAvailableService(t *testing.T) {
+	admins := &AdminsMock{GetFunc: func(ctx context.Context, p *GetUserRequest) (*User, error) {
+		return &User{Name: "admin " + p.Name}, nil
+	}}
+	k := NewTestKit(&ConnConfig{Admins: admins})
+	defer k.Close()
+
+	user, err := k.Client.Admins.Get(context.Background(), &GetUserRequest{Name: "ann"})
+	if err != nil || user.Name != "admin ann" {
+		t.Fatalf("got %v %v", user, err)
+	}
+	if _, err := k.Client.Users.Count(context.Background()); CodeOf(err) != CodeUnimplemented {
+		t.Fatalf("got %v", err)
+	}
+}
+
+func TestSharedNameDispatchedToFirstService(t *testing.T) {
+	users := &UsersMock{GetFunc: func(ctx context.Context, p *GetUserRequest) (*User, error) {
+		return &User{Name: "user " + p.Name}, nil
+	}}
+	admins := &AdminsMock{}
+	k := NewTestKit(&ConnConfig{Users: users, Admins: admins})
+	defer k.Close()
+
+	user, err := k.Client.Users.Get(context.Background(), &GetUserRequest{Name: "ann"})
+	if err != nil || user.Name != "user ann" {
+		t.Fatalf("got %v %v", user, err)
+	}
+	if len(admins.GetCalls()) != 0 {
+		t.Fatal("shared name dispatched to the second service")
+	}
+}
+
+func TestSharedNameWithoutService(t *testing.T) {
+	k := NewTestKit(&ConnConfig{})
+	defer k.Close()
+
+	_, err := k.Client.Users.Get(context.Background(), &GetUserRequest{})
+	if CodeOf(err) != CodeUnimplemented {
+		t.Fatalf("got %v", err)
+	}
+}
@@ -0,0 +1,37 @@
+import { MemoryTransport, Server, type ServerOptions } from './rpc-handler_gen';
+import { Connection, type ConnectionOptions, type Handlers, type Socket } from './rpc-server_gen';
+
+/** Serves the handlers on one end of a MemoryTransport pair and returns the other end */
+function serve(handlers: Handlers, options?: ConnectionOptions) {
+  const [client, remote] = MemoryTransport.pair();
+  const socket: Socket = {
+    send: (data) => remote.send(data),
+    close: () => remote.close(),
+    on: (event: 'message' | 'close', listener: (data: Uint8Array) => void) => {
+      if (event === 'message') {
+        remote.onMessage(listener);
+      } else {
+        remote.onClose(() => listener(new Uint8Array([])));
+      }
+    },
+  };
+  return { client, connection: new Connection(socket, handlers, options) };
+}
+
+/** Connects a client and a server over a MemoryTransport */
+export function connect(handlers: Handlers, options?: ConnectionOptions, serverOptions?: ServerOptions) {
+  const { client, connection } = serve(handlers, options);
+  const server = new Server(client, serverOptions);
+  return { server, connection, transport: client };
+}
+
+/** Connects a client which reconnects, every attempt gets a new transport */
+export function connectReconnecting(handlers: Handlers, options?: ConnectionOptions, serverOptions?: ServerOptions) {
+  const transports: MemoryTransport[] = [];
+  const server = new Server(() => {
+    const { client } = serve(handlers, options);
+    transports.push(client);
+    return client;
+  }, serverOptions);
+  return { server, transports };
+}
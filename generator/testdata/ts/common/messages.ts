@@ -0,0 +1,49 @@
+// Stands in for the code generated by pbjs -t static-module from messages.proto, the messages are encoded as JSON
+
+function encode(message: object) {
+  return { finish: () => new TextEncoder().encode(JSON.stringify(message)) };
+}
+
+function decode<T extends object>(message: T, data: Uint8Array): T {
+  return data.length ? Object.assign(message, JSON.parse(new TextDecoder().decode(data))) : message;
+}
+
+export class Void {
+  static encode = encode;
+  static decode(data: Uint8Array): Void {
+    return decode(new Void(), data);
+  }
+}
+
+export class Error {
+  Error = '';
+  static encode = encode;
+  static decode(data: Uint8Array): Error {
+    return decode(new Error(), data);
+  }
+}
+
+export class User {
+  name = '';
+  age = 0;
+  static encode = encode;
+  static decode(data: Uint8Array): User {
+    return decode(new User(), data);
+  }
+}
+
+export class GetUserRequest {
+  name = '';
+  static encode = encode;
+  static decode(data: Uint8Array): GetUserRequest {
+    return decode(new GetUserRequest(), data);
+  }
+}
+
+export class Chat {
+  text = '';
+  static encode = encode;
+  static decode(data: Uint8Array): Chat {
+    return decode(new Chat(), data);
+  }
+}
@@ -0,0 +1,11 @@
+// resolves the extensionless relative imports of the generated code to the .ts files
+import { register } from 'node:module';
+
+register('data:text/javascript,' + encodeURIComponent(`
+export async function resolve(specifier, context, next) {
+  if (specifier.startsWith('.') && !/\\.[cm]?[jt]s$/.test(specifier)) {
+    specifier += '.ts';
+  }
+  return next(specifier, context);
+}
+`));
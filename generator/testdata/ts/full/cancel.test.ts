@@ -0,0 +1,41 @@
+import { strict as assert } from 'node:assert';
+import { test } from 'node:test';
+import { connect } from './connect';
+import { User } from './messages';
+import type { RequestContext } from './rpc-server_gen';
+
+test('aborting a call waiting for the handshake rejects it', async () => {
+  const { server } = connect(
+    { userService: { getUser: () => new User(), ping: () => {}, setUser: () => {}, limited: () => new User() } },
+    { authenticate: () => new Promise<string>(() => {}) },
+  );
+  const controller = new AbortController();
+  const call = server.userService.ping({ signal: controller.signal });
+  setTimeout(() => controller.abort(), 20);
+  await assert.rejects(call, { name: 'AbortError' });
+});
+
+test('aborting a running call aborts the signal of the handler', async () => {
+  let handlerSignal: AbortSignal | undefined;
+  const started = Promise.withResolvers<void>();
+  const { server } = connect({
+    userService: {
+      getUser: () => new User(),
+      ping: (ctx: RequestContext) =>
+        new Promise<void>((resolve) => {
+          handlerSignal = ctx.signal;
+          ctx.signal.addEventListener('abort', () => resolve());
+          started.resolve();
+        }),
+      setUser: () => {},
+      limited: () => new User(),
+    },
+  });
+  const controller = new AbortController();
+  const call = server.userService.ping({ signal: controller.signal });
+  await started.promise;
+  controller.abort();
+  await assert.rejects(call, { name: 'AbortError' });
+  await new Promise((resolve) => setTimeout(resolve, 20));
+  assert.ok(handlerSignal?.aborted);
+});
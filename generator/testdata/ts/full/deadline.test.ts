@@ -0,0 +1,34 @@
+import { strict as assert } from 'node:assert';
+import { test } from 'node:test';
+import { connect } from './connect';
+import { User, type GetUserRequest } from './messages';
+import type { RequestContext } from './rpc-server_gen';
+
+function userService(onGetUser: (ctx: RequestContext) => void = () => {}) {
+  return {
+    getUser: (prm: GetUserRequest, ctx: RequestContext) => {
+      onGetUser(ctx);
+      return Object.assign(new User(), { name: prm.name });
+    },
+    ping: () => {},
+    setUser: () => {},
+    limited: () => new User(),
+  };
+}
+
+test('the timeout covers the wait for the handshake', async () => {
+  const { server } = connect({ userService: userService() }, { authenticate: () => new Promise<string>(() => {}) });
+  const start = Date.now();
+  await assert.rejects(server.userService.getUser({ name: 'ann' }, { timeoutMs: 50 }), { code: 'deadline_exceeded' });
+  assert.ok(Date.now() - start < 1000);
+});
+
+test('the server receives the remaining time', async () => {
+  let timeoutMs = 0;
+  const { server } = connect(
+    { userService: userService((ctx) => (timeoutMs = Number(ctx.metadata['timeout-ms']))) },
+    { authenticate: () => new Promise<string>((resolve) => setTimeout(() => resolve('ann'), 100)) },
+  );
+  assert.equal((await server.userService.getUser({ name: 'ann' }, { timeoutMs: 1000 })).name, 'ann');
+  assert.ok(timeoutMs > 0 && timeoutMs <= 920, 'timeout-ms ' + timeoutMs);
+});
@@ -0,0 +1,68 @@
+import { strict as assert } from 'node:assert';
+import { test } from 'node:test';
+import { connect, connectReconnecting } from './connect';
+import { User } from './messages';
+import { StatusError } from './rpc-server_gen';
+import type { StateChange } from './rpc-handler_gen';
+
+const handlers = { userService: { getUser: () => new User(), ping: () => {}, setUser: () => {}, limited: () => new User() } };
+const lost = { code: 1006, reason: '', wasClean: false };
+
+test('a rejected handshake closes the client with the error', async () => {
+  let token = 'wrong';
+  const { server } = connect(
+    handlers,
+    {
+      authenticate: (metadata) => {
+        if (metadata.token !== 'secret') {
+          throw new StatusError('unauthenticated', 'invalid token');
+        }
+        return 'ann';
+      },
+    },
+    { credentials: () => ({ token }) },
+  );
+  const changes: StateChange[] = [];
+  server.onStateChange((change) => changes.push(change));
+
+  await assert.rejects(server.whenReady(), { code: 'unauthenticated' });
+  assert.equal(server.state, 'closed');
+  assert.equal(changes.length, 1);
+  assert.equal((changes[0].error as { code?: string }).code, 'unauthenticated');
+  await assert.rejects(server.userService.ping(), { code: 'unauthenticated' });
+
+  // repeating the handshake opens the connection
+  token = 'secret';
+  await server.authenticate();
+  assert.equal(server.state, 'open');
+  await server.userService.ping();
+});
+
+test('a rejected handshake after a reconnect closes the client', async () => {
+  let attempts = 0;
+  const { server, transports } = connectReconnecting(
+    handlers,
+    {
+      authenticate: () => {
+        if (++attempts > 1) {
+          throw new StatusError('unauthenticated', 'session expired');
+        }
+        return 'ann';
+      },
+    },
+    { reconnect: { initialDelayMs: 10 } },
+  );
+  const changes: StateChange[] = [];
+  server.onStateChange((change) => changes.push(change));
+  await server.whenReady();
+  transports[0].close(lost);
+  await new Promise((resolve) => setTimeout(resolve));
+
+  await assert.rejects(server.whenReady(), { code: 'unauthenticated' });
+  assert.deepEqual(
+    changes.map((change) => change.state),
+    ['open', 'reconnecting', 'closed'],
+  );
+  assert.ok(changes[2].error);
+  server.close();
+});
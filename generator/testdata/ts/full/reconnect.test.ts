@@ -0,0 +1,42 @@
+import { strict as assert } from 'node:assert';
+import { test } from 'node:test';
+import { connectReconnecting } from './connect';
+import { User } from './messages';
+import type { StateChange } from './rpc-handler_gen';
+
+const handlers = { userService: { getUser: () => new User(), ping: () => {}, setUser: () => {}, limited: () => new User() } };
+const lost = { code: 1006, reason: '', wasClean: false };
+
+function states(changes: StateChange[]) {
+  return changes.map((change) => change.state);
+}
+
+test('the client reconnects after the connection was lost', async () => {
+  const { server, transports } = connectReconnecting(handlers, undefined, { reconnect: { initialDelayMs: 10 } });
+  const changes: StateChange[] = [];
+  server.onStateChange((change) => changes.push(change));
+  await server.whenReady();
+  transports[0].close(lost);
+  await new Promise((resolve) => setTimeout(resolve));
+  await server.userService.ping();
+  assert.equal(transports.length, 2);
+  assert.deepEqual(states(changes), ['open', 'reconnecting', 'open']);
+  assert.equal(changes[1].close?.code, 1006);
+  server.close();
+});
+
+test('closing the client while reconnecting stops reconnecting', async () => {
+  const { server, transports } = connectReconnecting(handlers, undefined, { reconnect: { initialDelayMs: 50 } });
+  await server.whenReady();
+  transports[0].close(lost);
+  await new Promise((resolve) => setTimeout(resolve));
+  assert.equal(server.state, 'reconnecting');
+
+  const call = server.userService.ping();
+  server.close();
+  assert.equal(server.state, 'closed');
+  await assert.rejects(call, { code: 'unavailable' });
+  await assert.rejects(server.whenReady(), { code: 'unavailable' });
+  await new Promise((resolve) => setTimeout(resolve, 100));
+  assert.equal(transports.length, 1);
+});
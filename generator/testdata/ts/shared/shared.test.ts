@@ -0,0 +1,23 @@
+import { strict as assert } from 'node:assert';
+import { test } from 'node:test';
+import { connect } from './connect';
+import { GetUserRequest, User } from './messages';
+
+function users(prefix: string) {
+  return {
+    get: (prm: GetUserRequest) => Object.assign(new User(), { name: prefix + prm.name }),
+    ping: () => {},
+    count: () => new User(),
+  };
+}
+
+test('a shared name is dispatched to the service with a handler', async () => {
+  const { server } = connect({ admins: users('admin ') });
+  assert.equal((await server.admins.get({ name: 'ann' })).name, 'admin ann');
+  await assert.rejects(server.users.count(), { code: 'unimplemented' });
+});
+
+test('a shared name is dispatched to the first service', async () => {
+  const { server } = connect({ users: users('user '), admins: users('admin ') });
+  assert.equal((await server.users.get({ name: 'ann' })).name, 'user ann');
+});
@@ -0,0 +1,227 @@
+package generator
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/yoheimuta/go-protoparser/v4"
+	"github.com/yoheimuta/go-protoparser/v4/interpret/unordered"
+	"github.com/yoheimuta/go-protoparser/v4/parser"
+)
+
+// GenerateTypeScriptReactHooks generates React hooks calling the rpc services and subscribing to the push messages
+func GenerateTypeScriptReactHooks(pbuf *parser.Proto, protoDir string, protoFile string, tsBaseDir string, runtime TsRuntime) error {
+	pb, err := protoparser.UnorderedInterpret(pbuf)
+	if err != nil {
+		return err
+	}
+
+	code := generateTypeScriptReactHooks(pb, protoDir, protoFile, runtime)
+
+	filename := fmt.Sprintf("%s/%s.ts", tsBaseDir, "react-hooks_gen")
+	err = writeFile(filename, code)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// isIdempotent checks the standard idempotency_level option of a method
+func isIdempotent(rpc *parser.RPC) bool {
+	for _, opt := range rpc.Options {
+		if opt.OptionName == "idempotency_level" {
+			return opt.Constant == "NO_SIDE_EFFECTS" || opt.Constant == "IDEMPOTENT"
+		}
+	}
+	return false
+}
+
+func generateTypeScriptReactHooks(pb *unordered.Proto, protoDir string, protoFile string, runtime TsRuntime) string {
+	var sb strings.Builder
+	w := func(s string) { sb.WriteString(s + "\n") }
+	dto := make(dtoCollectorType)
+
+	w(`
+const ServerContext = createContext<Server | undefined>(undefined);
+
+/** Provides the server to the hooks of all child components */
+export function ServerProvider({ server, children }: { server: Server; children?: ReactNode }) {
+  return createElement(ServerContext.Provider, { value: server }, children);
+}
+
+export function useServer(): Server {
+  const server = useContext(ServerContext);
+  if (!server) {
+    throw new globalThis.Error('useServer must be used within a ServerProvider');
+  }
+  return server;
+}
+
+export type QueryOptions = CallOptions & {
+  /** The call is only made if enabled, true by default */
+  enabled?: boolean;
+};
+
+export type QueryResult<T> = {
+  data?: T;
+  error?: unknown;
+  loading: boolean;
+  refetch: () => Promise<void>;
+};
+
+export type MutationResult<A extends unknown[], T> = {
+  data?: T;
+  error?: unknown;
+  loading: boolean;
+  mutate: (...args: A) => Promise<T>;
+  reset: () => void;
+};
+
+type CallState<T> = {
+  data?: T;
+  error?: unknown;
+  loading: boolean;
+};
+
+/** Identifies the parameter of a query, so a new object with the same content doesn't trigger a call */
+function paramKey(prm: unknown): string {
+  return JSON.stringify(prm, (_, value) => (typeof value === 'bigint' ? value.toString() : value));
+}
+
+/** Calls on mount and whenever the dependencies change. Outdated calls are aborted. */
+function useQuery<T>(call: (options: CallOptions) => Promise<T>, deps: unknown[], options: QueryOptions = {}): QueryResult<T> {
+  const enabled = options.enabled !== false;
+  const [state, setState] = useState<CallState<T>>({ loading: enabled });
+  const callRef = useRef(call);
+  callRef.current = call;
+  const optionsRef = useRef(options);
+  optionsRef.current = options;
+  const controllerRef = useRef<AbortController | undefined>(undefined);
+
+  const refetch = useCallback(async () => {
+    controllerRef.current?.abort();
+    const controller = new AbortController();
+    controllerRef.current = controller;
+    setState((s) => ({ ...s, loading: true, error: undefined }));
+    try {
+      const data = await callRef.current({ ...optionsRef.current, signal: controller.signal });
+      if (!controller.signal.aborted) {
+        setState({ data, loading: false });
+      }
+    } catch (error) {
+      if (!controller.signal.aborted) {
+        setState((s) => ({ ...s, error, loading: false }));
+      }
+    }
+  }, []);
+
+  useEffect(() => {
+    if (!enabled) {
+      return;
+    }
+    refetch();
+    return () => controllerRef.current?.abort();
+  }, [...deps, enabled]);
+
+  return { ...state, refetch };
+}
+
+/** Calls on every mutate, e.g. in an event handler */
+function useMutation<A extends unknown[], T>(call: (...args: A) => Promise<T>): MutationResult<A, T> {
+  const [state, setState] = useState<CallState<T>>({ loading: false });
+  const callRef = useRef(call);
+  callRef.current = call;
+
+  const mutate = useCallback(async (...args: A) => {
+    setState((s) => ({ ...s, loading: true, error: undefined }));
+    try {
+      const data = await callRef.current(...args);
+      setState({ data, loading: false });
+      return data;
+    } catch (error) {
+      setState((s) => ({ ...s, error, loading: false }));
+      throw error;
+    }
+  }, []);
+  const reset = useCallback(() => setState({ loading: false }), []);
+
+  return { ...state, mutate, reset };
+}
+`)
+
+	for _, srv := range pb.ProtoBody.Services {
+		svc := "server." + firstCharToLower(srv.ServiceName)
+
+		if hasServiceOption(srv, "(is_rpc)") {
+			for _, rpc := range srv.ServiceBody.RPCs {
+				name := firstCharToUpper(rpc.RPCName)
+				method := svc + "." + firstCharToLower(rpc.RPCName)
+				hasParam := rpc.RPCRequest.MessageType != voidTypeName
+				resp := "void"
+				if rpc.RPCResponse.MessageType != voidTypeName {
+					resp = rpc.RPCResponse.MessageType
+					dto[resp] = struct{}{}
+				}
+				if hasParam {
+					dto[rpc.RPCRequest.MessageType] = struct{}{}
+				}
+
+				// Query, only for methods without side effects, as they are called on every mount
+				if isIdempotent(rpc) {
+					w("/** Calls " + rpc.RPCName + " when the component mounts and whenever the parameter changes */")
+					if hasParam {
+						w("export function use" + name + "(prm: " + rpc.RPCRequest.MessageType + ", options?: QueryOptions): QueryResult<" + resp + "> {")
+						w("  const server = useServer();")
+						w("  return useQuery((callOptions) => " + method + "(prm, callOptions), [server, paramKey(prm)], options);")
+					} else {
+						w("export function use" + name + "(options?: QueryOptions): QueryResult<" + resp + "> {")
+						w("  const server = useServer();")
+						w("  return useQuery((callOptions) => " + method + "(callOptions), [server], options);")
+					}
+					w("}\n")
+					continue
+				}
+
+				// Mutation
+				w("/** Calls " + rpc.RPCName + " on every mutate */")
+				if hasParam {
+					w("export function use" + name + "Mutation(): MutationResult<[prm: " + rpc.RPCRequest.MessageType + ", options?: CallOptions], " + resp + "> {")
+					w("  const server = useServer();")
+					w("  return useMutation((prm: " + rpc.RPCRequest.MessageType + ", options?: CallOptions) => " + method + "(prm, options));")
+				} else {
+					w("export function use" + name + "Mutation(): MutationResult<[options?: CallOptions], " + resp + "> {")
+					w("  const server = useServer();")
+					w("  return useMutation((options?: CallOptions) => " + method + "(options));")
+				}
+				w("}\n")
+			}
+		}
+
+		if hasServiceOption(srv, "(is_ssp)") {
+			for _, rpc := range srv.ServiceBody.RPCs {
+				name := firstCharToUpper(rpc.RPCName)
+				w("/** Calls cb for every " + rpc.RPCName + " message while the component is mounted */")
+				if rpc.RPCRequest.MessageType != voidTypeName {
+					dto[rpc.RPCRequest.MessageType] = struct{}{}
+					w("export function use" + name + "(cb: (p: " + rpc.RPCRequest.MessageType + ") => void) {")
+					w("  const server = useServer();")
+					w("  const cbRef = useRef(cb);")
+					w("  cbRef.current = cb;")
+					w("  useEffect(() => " + svc + ".on" + name + "((p) => cbRef.current(p)), [server]);")
+				} else {
+					w("export function use" + name + "(cb: () => void) {")
+					w("  const server = useServer();")
+					w("  const cbRef = useRef(cb);")
+					w("  cbRef.current = cb;")
+					w("  useEffect(() => " + svc + ".on" + name + "(() => cbRef.current()), [server]);")
+				}
+				w("}\n")
+			}
+		}
+	}
+
+	imports := "import { createContext, createElement, type ReactNode, useCallback, useContext, useEffect, useRef, useState } from 'react';\n" +
+		"import { type CallOptions, Server } from './rpc-handler_gen';\n" +
+		generateImports(pb, protoDir, protoFile, dto, runtime, false)
+	return imports + generatorWarning + sb.String()
+}
@@ -13,13 +13,13 @@ import (
 	"github.com/yoheimuta/go-protoparser/v4/parser"
 )
 
-func GenerateTypeScriptFile(pbuf *parser.Proto, protoDir string, protoFile string, tsBaseDir string) error {
+func GenerateTypeScriptFile(pbuf *parser.Proto, protoDir string, protoFile string, tsBaseDir string, runtime TsRuntime) error {
 	pb, err := protoparser.UnorderedInterpret(pbuf)
 	if err != nil {
 		return err
 	}
 
-	code := generateTypeScriptCode(pb, protoDir, protoFile)
+	code := generateTypeScriptCode(pb, protoDir, protoFile, runtime)
 
 	filename := fmt.Sprintf("%s/%s.ts", tsBaseDir, "rpc-handler_gen")
 	err = writeFile(filename, code)
@@ -29,16 +29,16 @@ func GenerateTypeScriptFile(pbuf *parser.Proto, protoDir string, protoFile strin
 	return nil
 }
 
-func generateTypeScriptCode(pb *unordered.Proto, protoDir string, protoFile string) string {
+func generateTypeScriptCode(pb *unordered.Proto, protoDir string, protoFile string, runtime TsRuntime) string {
 	dtoCollector := make(dtoCollectorType)
 	dtoCollector["Error"] = struct{}{}
 
-	types := generateTypeScriptHeader()
-	serverClass := generateRpcServerClass(pb)
+	types := generateTypeScriptHeader() + generateTransports()
+	serverClass := generateRpcServerClass(pb, runtime)
 	encoder := generateEncoder()
-	rpcServiceImpls := generateRpcServices(pb, dtoCollector)
-	sspServiceImpls := generateSspServices(pb, dtoCollector)
-	imports := generateImports(pb, protoDir, protoFile, dtoCollector)
+	rpcServiceImpls := generateRpcServices(pb, dtoCollector, runtime)
+	sspServiceImpls := generateSspServices(pb, dtoCollector, runtime)
+	imports := runtime.runtimeImport(false) + generateImports(pb, protoDir, protoFile, dtoCollector, runtime, true)
 
 	return imports + types + serverClass + encoder + rpcServiceImpls + sspServiceImpls
 }
@@ -49,88 +49,778 @@ type ResolveFunctions = {
   name: string;
   resolve?: (arg: any) => void;
   reject?: (arg: any) => void;
+  onResponseMetadata?: (metadata: Metadata) => void;
 };
 
+/** Key/value pairs sent along with a request or a response */
+export type Metadata = Record<string, string>;
+
+export type ServerOptions = {
+  /** Returns the W3C traceparent of the current span, which is attached to every request */
+  traceparent?: () => string | undefined;
+  /** Metadata sent with every request */
+  metadata?: Metadata | (() => Metadata);
+  /** Called for every response carrying metadata */
+  onResponseMetadata?: (name: string, metadata: Metadata) => void;
+  /** Default timeout of all calls in milliseconds */
+  timeoutMs?: number;
+  /** Called if the server was generated from a different schema, e.g. to prompt the user to reload */
+  onSchemaMismatch?: (serverFingerprint: string) => void;
+  /** Reconnects with jittered exponential backoff when the server was created with a URL or factory */
+  reconnect?: ReconnectOptions | false;
+  /** Calls made while disconnected wait for the connection ('queue', default) or fail immediately ('fail') */
+  whileDisconnected?: 'queue' | 'fail';
+  /** Credentials sent in the handshake on connect. Calls wait until the handshake has succeeded. */
+  credentials?: () => Metadata | Promise<Metadata>;
+};
+
+/** Error of a failed rpc call */
+export class RpcError extends globalThis.Error {
+  /** Status code, e.g. 'not_found' */
+  readonly code: string;
+  /** Metadata of the error response with the details of the error */
+  readonly metadata: Metadata;
+
+  constructor(message: string, metadata: Metadata) {
+    super(message);
+    this.name = 'RpcError';
+    this.code = metadata.code ?? 'unknown';
+    this.metadata = metadata;
+  }
+
+  /** Milliseconds to wait before retrying a call rejected by a rate limit */
+  get retryAfterMs(): number | undefined {
+    const retryAfter = this.metadata['retry-after-ms'];
+    return retryAfter ? Number(retryAfter) : undefined;
+  }
+}
+
+export type ReconnectOptions = {
+  /** Delay before the first reconnect in milliseconds, 500 by default */
+  initialDelayMs?: number;
+  /** Maximum delay between reconnects in milliseconds, 30000 by default */
+  maxDelayMs?: number;
+  /** Factor the delay grows with on every failed attempt, 2 by default */
+  multiplier?: number;
+  /** Number of attempts before giving up, unlimited by default */
+  maxAttempts?: number;
+};
+
+/** State of the connection. It is 'open' once the handshake has succeeded. */
+export type ConnectionState = 'connecting' | 'open' | 'reconnecting' | 'closed';
+
+/** Close codes defined by RFC 6455 */
+export const CloseCode = {
+  Normal: 1000,
+  GoingAway: 1001,
+  ProtocolError: 1002,
+  UnsupportedData: 1003,
+  NoStatus: 1005,
+  Abnormal: 1006,
+  InvalidData: 1007,
+  PolicyViolation: 1008,
+  MessageTooBig: 1009,
+  InternalError: 1011,
+  ServiceRestart: 1012,
+  TryAgainLater: 1013,
+} as const;
+
+/** Why a connection was closed */
+export type CloseInfo = {
+  code: number;
+  reason: string;
+  wasClean: boolean;
+};
+
+export type StateChange = {
+  state: ConnectionState;
+  previous: ConnectionState;
+  /** Set when the change was caused by a closed connection */
+  close?: CloseInfo;
+  /** Set when the change was caused by a failed handshake */
+  error?: unknown;
+};
+
+/**
+ * Stream of push messages. Consume it with for await, or as Observable, e.g. with RxJS from().
+ * Every iteration and subscription registers its own listener.
+ */
+export class PushStream<T> implements AsyncIterable<T> {
+  constructor(private readonly listen: (cb: (value: T) => void) => () => void) {
+    const observable = (Symbol as { observable?: symbol }).observable;
+    if (observable) {
+      (this as any)[observable] = () => this;
+    }
+  }
+
+  subscribe(observer: ((value: T) => void) | { next?: (value: T) => void }): { unsubscribe: () => void } {
+    const next = typeof observer === 'function' ? observer : (value: T) => observer.next?.(value);
+    return { unsubscribe: this.listen(next) };
+  }
+
+  ['@@observable']() {
+    return this;
+  }
+
+  [Symbol.asyncIterator](): AsyncIterator<T> {
+    const queue: T[] = [];
+    let waiting: ((result: IteratorResult<T>) => void) | undefined;
+    let done = false;
+    const unsubscribe = this.listen((value) => {
+      if (waiting) {
+        const resolve = waiting;
+        waiting = undefined;
+        resolve({ value, done: false });
+      } else {
+        queue.push(value);
+      }
+    });
+
+    return {
+      next: () => {
+        if (queue.length > 0) {
+          return Promise.resolve({ value: queue.shift()!, done: false });
+        }
+        if (done) {
+          return Promise.resolve({ value: undefined, done: true });
+        }
+        return new Promise((resolve) => (waiting = resolve));
+      },
+      return: () => {
+        done = true;
+        queue.length = 0;
+        unsubscribe();
+        waiting?.({ value: undefined, done: true });
+        waiting = undefined;
+        return Promise.resolve({ value: undefined, done: true });
+      },
+    };
+  }
+}
+
+type PushListener = {
+  cb: (data: unknown) => void;
+  once: boolean;
+};
+
+export type CallOptions = {
+  /** Metadata sent with this request, overriding the global metadata */
+  metadata?: Metadata;
+  /** Called with the metadata of the response */
+  onResponseMetadata?: (metadata: Metadata) => void;
+  /** Timeout of this call in milliseconds. The server cancels the handler when it expires. */
+  timeoutMs?: number;
+  /** Aborts the call and cancels the handler on the server */
+  signal?: AbortSignal;
+};
+
+`
+}
+
+func generateTransports() string {
+	return `
+/**
+ * Carries the frames between client and server. Implementations deliver incoming frames as Uint8Array,
+ * whatever binary type the underlying channel uses.
+ */
+export interface Transport {
+  /** Resolves when frames can be sent, rejects if the transport closes before */
+  readonly opened: Promise<void>;
+  /** True while frames can be sent */
+  readonly isOpen: boolean;
+  send(data: Uint8Array): void;
+  /** Registers the handler of incoming frames */
+  onMessage(handler: (data: Uint8Array) => void): void;
+  /** Registers the handler called once when the transport is closed */
+  onClose(handler: (close: CloseInfo) => void): void;
+  close(): void;
+}
+
+const closedInfo: CloseInfo = { code: CloseCode.Normal, reason: '', wasClean: true };
+
+/** Transport over a browser WebSocket */
+export class WebSocketTransport implements Transport {
+  readonly opened: Promise<void>;
+  private received: Promise<void> = Promise.resolve();
+  private messageHandler?: (data: Uint8Array) => void;
+
+  constructor(private readonly ws: WebSocket) {
+    ws.binaryType = 'arraybuffer';
+    this.opened = new Promise((resolve, reject) => {
+      if (ws.readyState === WebSocket.OPEN) {
+        resolve();
+      } else if (ws.readyState !== WebSocket.CONNECTING) {
+        reject(new globalThis.Error('connection closed'));
+      }
+      ws.addEventListener('open', () => resolve());
+      ws.addEventListener('close', () => reject(new globalThis.Error('connection closed')));
+    });
+    this.opened.catch(() => {});
+    ws.addEventListener('message', (evt) => {
+      const data: unknown = evt.data;
+      // a Blob is read asynchronously, so all frames are delivered in order after the previous one
+      this.received = this.received
+        .then(async () => this.messageHandler?.(data instanceof Blob ? new Uint8Array(await data.arrayBuffer()) : toBytes(data)))
+        .catch((err) => console.error('Failed to handle message: ', err));
+    });
+  }
+
+  get isOpen(): boolean {
+    return this.ws.readyState === WebSocket.OPEN;
+  }
+
+  send(data: Uint8Array) {
+    this.ws.send(data);
+  }
+
+  onMessage(handler: (data: Uint8Array) => void) {
+    this.messageHandler = handler;
+  }
+
+  onClose(handler: (close: CloseInfo) => void) {
+    this.ws.addEventListener('close', (evt) => {
+      // let the frames received before be handled first
+      this.received.then(() => handler({ code: evt.code, reason: evt.reason, wasClean: evt.wasClean }));
+    });
+  }
+
+  close() {
+    this.ws.close();
+  }
+}
+
+/** The part of a WebSocket of the ws package used by NodeWebSocketTransport */
+export interface NodeWebSocket {
+  readonly readyState: number;
+  send(data: Uint8Array): void;
+  close(): void;
+  on(event: 'open', listener: () => void): unknown;
+  on(event: 'message', listener: (data: Uint8Array | ArrayBuffer | Uint8Array[]) => void): unknown;
+  on(event: 'close', listener: (code: number, reason: Uint8Array) => void): unknown;
+}
+
+/** Transport over a WebSocket of the Node.js ws package, all its binary types are supported */
+export class NodeWebSocketTransport implements Transport {
+  readonly opened: Promise<void>;
+
+  constructor(private readonly ws: NodeWebSocket) {
+    this.opened = new Promise((resolve, reject) => {
+      if (ws.readyState === 1) {
+        resolve();
+      } else if (ws.readyState !== 0) {
+        reject(new globalThis.Error('connection closed'));
+      }
+      ws.on('open', () => resolve());
+      ws.on('close', () => reject(new globalThis.Error('connection closed')));
+    });
+    this.opened.catch(() => {});
+  }
+
+  get isOpen(): boolean {
+    return this.ws.readyState === 1;
+  }
+
+  send(data: Uint8Array) {
+    this.ws.send(data);
+  }
+
+  onMessage(handler: (data: Uint8Array) => void) {
+    this.ws.on('message', (data) => handler(toBytes(data)));
+  }
+
+  onClose(handler: (close: CloseInfo) => void) {
+    this.ws.on('close', (code, reason) => handler({ code, reason: new TextDecoder().decode(reason), wasClean: code !== CloseCode.Abnormal }));
+  }
+
+  close() {
+    this.ws.close();
+  }
+}
+
+/** The part of a MessagePort or Worker used by MessagePortTransport */
+export interface MessagePortLike {
+  postMessage(message: unknown, transfer: Transferable[]): void;
+  addEventListener(type: 'message', listener: (evt: MessageEvent) => void): void;
+  start?(): void;
+  close?(): void;
+}
+
+/**
+ * Transport over a MessagePort or Worker, e.g. to a server running in a worker.
+ * The frames are transferred as ArrayBuffer, null closes the transport on both ends.
+ */
+export class MessagePortTransport implements Transport {
+  readonly opened = Promise.resolve();
+  private open = true;
+  private readonly closeHandlers: ((close: CloseInfo) => void)[] = [];
+  private messageHandler?: (data: Uint8Array) => void;
+
+  constructor(private readonly port: MessagePortLike) {
+    port.addEventListener('message', (evt) => {
+      if (evt.data === null) {
+        this.closed();
+      } else if (this.open) {
+        this.messageHandler?.(toBytes(evt.data));
+      }
+    });
+    port.start?.();
+  }
+
+  get isOpen(): boolean {
+    return this.open;
+  }
+
+  send(data: Uint8Array) {
+    if (!this.open) {
+      throw new globalThis.Error('transport closed');
+    }
+    const copy = data.slice();
+    this.port.postMessage(copy.buffer, [copy.buffer]);
+  }
+
+  onMessage(handler: (data: Uint8Array) => void) {
+    this.messageHandler = handler;
+  }
+
+  onClose(handler: (close: CloseInfo) => void) {
+    this.closeHandlers.push(handler);
+  }
+
+  close() {
+    if (this.open) {
+      this.port.postMessage(null, []);
+      this.closed();
+      this.port.close?.();
+    }
+  }
+
+  private closed() {
+    if (!this.open) {
+      return;
+    }
+    this.open = false;
+    this.closeHandlers.forEach((handler) => handler(closedInfo));
+  }
+}
+
+/**
+ * In-memory transport, e.g. to test against a fake server.
+ * MemoryTransport.pair() returns two connected ends, frames are delivered asynchronously like over a network.
+ */
+export class MemoryTransport implements Transport {
+  readonly opened = Promise.resolve();
+  private peer?: MemoryTransport;
+  private open = true;
+  private readonly closeHandlers: ((close: CloseInfo) => void)[] = [];
+  private messageHandler?: (data: Uint8Array) => void;
+
+  static pair(): [MemoryTransport, MemoryTransport] {
+    const a = new MemoryTransport();
+    const b = new MemoryTransport();
+    a.peer = b;
+    b.peer = a;
+    return [a, b];
+  }
+
+  get isOpen(): boolean {
+    return this.open;
+  }
+
+  send(data: Uint8Array) {
+    if (!this.open) {
+      throw new globalThis.Error('transport closed');
+    }
+    const peer = this.peer!;
+    const copy = data.slice();
+    queueMicrotask(() => peer.open && peer.messageHandler?.(copy));
+  }
+
+  onMessage(handler: (data: Uint8Array) => void) {
+    this.messageHandler = handler;
+  }
+
+  onClose(handler: (close: CloseInfo) => void) {
+    this.closeHandlers.push(handler);
+  }
+
+  /** Closes both ends, the close info is passed to the close handlers, e.g. to simulate a lost connection */
+  close(close: CloseInfo = closedInfo) {
+    for (const end of [this, this.peer!]) {
+      if (end.open) {
+        end.open = false;
+        queueMicrotask(() => end.closeHandlers.forEach((handler) => handler(close)));
+      }
+    }
+  }
+}
+
+/** Wraps a browser WebSocket in a WebSocketTransport */
+function toTransport(transport: Transport | WebSocket): Transport {
+  return typeof WebSocket !== 'undefined' && transport instanceof WebSocket ? new WebSocketTransport(transport) : (transport as Transport);
+}
+
+/** Normalizes the binary types of the channels to Uint8Array */
+function toBytes(data: unknown): Uint8Array {
+  if (data instanceof Uint8Array) {
+    return data;
+  }
+  if (data instanceof ArrayBuffer) {
+    return new Uint8Array(data);
+  }
+  if (ArrayBuffer.isView(data)) {
+    return new Uint8Array(data.buffer, data.byteOffset, data.byteLength);
+  }
+  if (Array.isArray(data)) {
+    const result = new Uint8Array(data.reduce((len: number, chunk: Uint8Array) => len + chunk.length, 0));
+    let offset = 0;
+    for (const chunk of data as Uint8Array[]) {
+      result.set(chunk, offset);
+      offset += chunk.length;
+    }
+    return result;
+  }
+  throw new globalThis.Error('unsupported message data: ' + typeof data);
+}
 `
 }
 
-func generateRpcServerClass(pb *unordered.Proto) string {
+func generateRpcServerClass(pb *unordered.Proto, runtime TsRuntime) string {
 	var sb strings.Builder
 	w := func(s string) { sb.WriteString(s + "\n") }
 
+	w("/** Identifies the services and methods the code was generated from */")
+	w("export const SCHEMA_FINGERPRINT = '" + schemaFingerprint(pb) + "';\n")
 	sb.WriteString(`export class Server {
-  private readonly ws: WebSocket;
+  private transport!: Transport;
+  private readonly createTransport?: () => Transport;
+  private readonly options: ServerOptions;
   private readonly requestMap: { [key: number]: ResolveFunctions } = {};
   private readonly callbackListeners: { [key: string]: ((data: unknown) => void)[] } = {};
   private nextMessageId: number = 1;
+  private ready: Promise<void> = Promise.resolve();
+  // resolves with the attempt started by the close of the current transport
+  private closeHandled!: Promise<{ next?: Promise<void> }>;
+  private attempt = 0;
+  private closed = false;
+  // stops the reconnect attempt waiting for its delay
+  private stopReconnect?: () => void;
+  private currentState: ConnectionState = 'connecting';
+  private readonly stateListeners: ((change: StateChange) => void)[] = [];
 `)
 	for _, srv := range pb.ProtoBody.Services {
 		w("  readonly " + firstCharToLower(srv.ServiceName) + ": " + srv.ServiceName + "Impl;")
 	}
 	w("")
 
-	w(`  constructor(ws: WebSocket) {
-    this.ws = ws;
+	w(`  /**
+   * Creates the client for a transport or WebSocket, or for a URL or factory of them.
+   * Only with a URL or factory the connection is reestablished after it was lost.
+   */
+  constructor(transport: Transport | WebSocket | string | (() => Transport | WebSocket), options: ServerOptions = {}) {
+    this.options = options;
+    if (typeof transport === 'string') {
+      this.createTransport = () => new WebSocketTransport(new WebSocket(transport));
+    } else if (typeof transport === 'function') {
+      this.createTransport = () => toTransport(transport());
+    }
 `)
 	for _, srv := range pb.ProtoBody.Services {
 		w("    this." + firstCharToLower(srv.ServiceName) + " = new " + srv.ServiceName + "Impl(this);")
 	}
 	w("")
-	w("    this.initMessageHandler();")
+	w("    this.attach(typeof transport === 'object' ? toTransport(transport) : this.createTransport!());")
+	w("    this.authenticate().catch((err) => console.error('Handshake failed: ', err));")
 	w("  }\n")
 
-	w(`  private initMessageHandler() {
-    this.ws.onmessage = (evt) => {
-      const msg = decode(evt.data);
+	w(`  /**
+   * Performs the handshake with the credentials of the options and the schema fingerprint.
+   * It runs on connect and can be repeated, e.g. after the token changed. Calls wait until it has finished and fail if it fails.
+   */
+  authenticate(): Promise<void> {
+    this.ready = this.handshake();
+    return this.ready;
+  }
+
+  get state(): ConnectionState {
+    return this.currentState;
+  }
+
+  /** Registers a listener for state changes and returns a function removing it */
+  onStateChange(listener: (change: StateChange) => void): () => void {
+    this.stateListeners.push(listener);
+    return () => {
+      const i = this.stateListeners.indexOf(listener);
+      if (i >= 0) {
+        this.stateListeners.splice(i, 1);
+      }
+    };
+  }
 
-      if (msg.id) {
-        const promises = this.requestMap[msg.id] || this.requestMap[-msg.id];
-        if (!promises) {
-          console.error('No promise found for id ' + msg.id);
-          return;
+  /** Resolves when the connection is open, following reconnects. Rejects if the connection is closed for good. */
+  async whenReady(): Promise<void> {
+    for (;;) {
+      const ready = this.ready;
+      try {
+        await ready;
+        return;
+      } catch (err) {
+        if (ready === this.ready) {
+          throw err;
         }
+      }
+    }
+  }
+
+  private setState(state: ConnectionState, close?: CloseInfo, error?: unknown) {
+    const previous = this.currentState;
+    if (state === previous) {
+      return;
+    }
+    this.currentState = state;
+    const change: StateChange = { state, previous, close, error };
+    [...this.stateListeners].forEach((listener) => listener(change));
+  }
 
-        if (msg.id > 0) {
-          promises.resolve!(msg.data);
-        } else {
-          let err = Error.decode(msg.data);
-          promises.reject!(err.Error);
+  /** Closes the connection for good. Pending and following calls fail. */
+  close() {
+    this.closed = true;
+    if (this.stopReconnect) {
+      // the transport is closed already and reports no close anymore
+      this.stopReconnect();
+      this.stopReconnect = undefined;
+      this.setState('closed');
+    }
+    this.transport.close();
+  }
+
+  private async handshake(): Promise<void> {
+    const transport = this.transport;
+    const closeHandled = this.closeHandled;
+    await transport.opened.catch(() => {});
+    if (!transport.isOpen) {
+      if (this.createTransport) {
+        // the transport fails to open before its close is handled, follow the next attempt instead of failing the waiting calls
+        const { next } = await closeHandled;
+        if (next) {
+          return next;
         }
+      }
+      throw new RpcError('connection closed', { code: 'unavailable' });
+    }
+    this.attempt = 0;
+    const onResponseMetadata = (metadata: Metadata) => {
+      if (metadata.schema && metadata.schema !== SCHEMA_FINGERPRINT) {
+        this.options.onSchemaMismatch?.(metadata.schema);
+      }
+    };
+    try {
+      const credentials = await this.options.credentials?.();
+      await this.send('$hello', new Uint8Array([]), { ...credentials, schema: SCHEMA_FINGERPRINT }, { onResponseMetadata });
+    } catch (err) {
+      // a closed transport sets the state on its close, a rejected handshake leaves no usable connection
+      if (transport === this.transport && transport.isOpen) {
+        this.setState('closed', undefined, err);
+      }
+      throw err;
+    }
+    this.setState('open');
+  }
+`)
 
-        delete this.requestMap[msg.id]
+	w(`  private attach(transport: Transport) {
+    this.transport = transport;
+    transport.onMessage((data) => this.onMessage(data));
+    this.closeHandled = new Promise((resolve) => transport.onClose((close) => resolve({ next: this.onClose(transport, close) })));
+  }
+
+  /** Fails the pending calls and returns the next attempt, or undefined if the transport was already replaced */
+  private onClose(transport: Transport, close: CloseInfo): Promise<void> | undefined {
+    if (transport !== this.transport) {
+      return undefined;
+    }
+    const err = new RpcError('connection closed', { code: 'unavailable' });
+    for (const id of Object.keys(this.requestMap)) {
+      const promises = this.requestMap[Number(id)];
+      delete this.requestMap[Number(id)];
+      promises.reject!(err);
+    }
+
+    const reconnect = this.options.reconnect === false ? undefined : this.options.reconnect ?? {};
+    if (this.closed || !this.createTransport || !reconnect || this.attempt >= (reconnect.maxAttempts ?? Infinity)) {
+      this.ready = Promise.reject(err);
+      this.setState('closed', close);
+    } else {
+      this.setState('reconnecting', close);
+      // replace ready before the waiting calls see the failed attempt, so queued calls wait for the next one
+      const delay = Math.min(reconnect.maxDelayMs ?? 30000, (reconnect.initialDelayMs ?? 500) * Math.pow(reconnect.multiplier ?? 2, this.attempt++));
+      this.ready = new Promise<void>((resolve, reject) => {
+        const timer = setTimeout(resolve, delay * (0.5 + Math.random() / 2));
+        this.stopReconnect = () => {
+          clearTimeout(timer);
+          reject(err);
+        };
+      }).then(() => {
+        this.stopReconnect = undefined;
+        this.attach(this.createTransport!());
+        return this.handshake();
+      });
+    }
+    this.ready.catch(() => {});
+    return this.ready;
+  }
+
+  private onMessage(data: Uint8Array) {
+    const msg = decode(data);
+
+    if (msg.id) {
+      const promises = this.requestMap[msg.id] || this.requestMap[-msg.id];
+      if (!promises) {
+        console.error('No promise found for id ' + msg.id);
+        return;
+      }
+
+      if (Object.keys(msg.header).length > 0) {
+        this.options.onResponseMetadata?.(promises.name, msg.header);
+        promises.onResponseMetadata?.(msg.header);
+      }
+
+      if (msg.id > 0) {
+        promises.resolve!(msg.data);
+      } else {`)
+	w("        let err = " + runtime.decode("Error", "msg.data") + ";")
+	w(`        promises.reject!(new RpcError(err.Error, msg.header));
+      }
+
+      delete this.requestMap[Math.abs(msg.id)];
+    } else {
+      // call all registered listeners
+      const listeners = this.callbackListeners[msg.name!];
+      if (listeners) {
+        listeners.forEach((cb) => cb(msg.data));
       } else {
-        // call all registered listeners
-        const listeners = this.callbackListeners[msg.name!];
-        if (listeners) {
-          listeners.forEach((cb) => cb(msg.data));
-        } else {
-          console.error("No listener for: ", msg.name);
-        }
+        console.error("No listener for: ", msg.name);
       }
     }
   }
 `)
 
-	w(`  registerCallbackHandler(name: string, cb: (data: Uint8Array) => void) {
+	w(`  /** Registers a handler for a push message and returns a function removing it */
+  registerCallbackHandler(name: string, cb: (data: Uint8Array) => void): () => void {
     let listeners = this.callbackListeners[name];
     if (!listeners) {
       listeners = [];
       this.callbackListeners[name] = listeners;
     }
     listeners.push(cb as (data: unknown) => void);
+    return () => this.unregisterCallbackHandler(name, cb);
+  }
+
+  unregisterCallbackHandler(name: string, cb: (data: Uint8Array) => void) {
+    const listeners = this.callbackListeners[name];
+    const i = listeners ? listeners.indexOf(cb as (data: unknown) => void) : -1;
+    if (i >= 0) {
+      listeners.splice(i, 1);
+    }
   }
 `)
 
-	w(`  rpc(name: string, data: Uint8Array): Promise<Uint8Array> {
+	w(`  async rpc(name: string, data: Uint8Array, options: CallOptions = {}): Promise<Uint8Array> {
+    const globalMetadata = typeof this.options.metadata === 'function' ? this.options.metadata() : this.options.metadata;
+    const header: Metadata = { ...globalMetadata, ...options.metadata };
+    const traceparent = this.options.traceparent?.();
+    if (traceparent) {
+      header.traceparent = traceparent;
+    }
+    const timeoutMs = options.timeoutMs ?? this.options.timeoutMs;
+    // the deadline starts with the call, so waiting for the connection counts against it
+    const deadline = timeoutMs ? Date.now() + timeoutMs : undefined;
+    options.signal?.throwIfAborted();
+    if (this.options.whileDisconnected === 'fail' && !this.transport.isOpen) {
+      throw new RpcError('not connected', { code: 'unavailable' });
+    }
+    // queued calls follow the reconnect attempts until one succeeds or reconnecting stops
+    await this.readyForCall(name, deadline, options.signal);
+    if (deadline === undefined) {
+      return this.send(name, data, header, options);
+    }
+    // the server gets the remaining time
+    const remainingMs = deadline - Date.now();
+    if (remainingMs <= 0) {
+      throw this.deadlineExceeded(name);
+    }
+    header['timeout-ms'] = String(remainingMs);
+    return this.send(name, data, header, { ...options, timeoutMs: remainingMs });
+  }
+
+  /** Waits like whenReady, but rejects when the deadline of the call passes or its signal is aborted first */
+  private readyForCall(name: string, deadline?: number, signal?: AbortSignal): Promise<void> {
+    if (deadline === undefined && !signal) {
+      return this.whenReady();
+    }
+    return new Promise((resolve, reject) => {
+      let timer: ReturnType<typeof setTimeout> | undefined;
+      if (deadline !== undefined) {
+        timer = setTimeout(() => reject(this.deadlineExceeded(name)), deadline - Date.now());
+      }
+      const onAbort = () => reject(signal!.reason);
+      signal?.addEventListener('abort', onAbort, { once: true });
+      this.whenReady()
+        .then(resolve, reject)
+        .finally(() => {
+          clearTimeout(timer);
+          signal?.removeEventListener('abort', onAbort);
+        });
+    });
+  }
+
+  private deadlineExceeded(name: string): RpcError {
+    return new RpcError("deadline of '" + name + "' exceeded", { code: 'deadline_exceeded' });
+  }
+
+  private send(name: string, data: Uint8Array, header: Metadata, options: CallOptions = {}): Promise<Uint8Array> {
     const id = this.nextMessageId++;
-    const request = encode(id, name, data);
-    const promiseFunctions: ResolveFunctions = { name };
+    const request = encode(id, name, data, header);
+    const promiseFunctions: ResolveFunctions = { name, onResponseMetadata: options.onResponseMetadata };
     const promise = new Promise((resolve, reject) => {
-      promiseFunctions.resolve = resolve;
-      promiseFunctions.reject = reject;
+      const signal = options.signal;
+      let timer: ReturnType<typeof setTimeout> | undefined;
+      const onAbort = () => {
+        clearTimeout(timer);
+        delete this.requestMap[id];
+        if (this.transport.isOpen) {
+          this.transport.send(encode(id, '$cancel', new Uint8Array([])));
+        }
+        reject(signal!.reason);
+      };
+      const settle = () => {
+        clearTimeout(timer);
+        signal?.removeEventListener('abort', onAbort);
+      };
+
+      if (options.timeoutMs) {
+        timer = setTimeout(() => {
+          settle();
+          delete this.requestMap[id];
+          reject(this.deadlineExceeded(name));
+        }, options.timeoutMs);
+      }
+      signal?.addEventListener('abort', onAbort, { once: true });
+      promiseFunctions.resolve = (arg) => {
+        settle();
+        resolve(arg);
+      };
+      promiseFunctions.reject = (arg) => {
+        settle();
+        reject(arg);
+      };
     });
     this.requestMap[id] = promiseFunctions;
-    this.ws.send(request);
+    try {
+      this.transport.send(request);
+    } catch {
+      delete this.requestMap[id];
+      promiseFunctions.reject!(new RpcError('connection closed', { code: 'unavailable' }));
+    }
     return promise as Promise<Uint8Array>;
   }`)
 
@@ -146,14 +836,20 @@ func generateEncoder() string {
 export type ResponseContainer = {
   name?: string;
   id: number;
+  header: Metadata;
   data: Uint8Array;
 };
 
 /**
- * Encodes an RPC request to a binary representation
+ * Encodes an RPC request to a binary representation.
+ * The header is appended to the name as url encoded query.
  */
-function encode(id: number, name: string, data: Uint8Array): Uint8Array {
+function encode(id: number, name: string, data: Uint8Array, header: Record<string, string> = {}): Uint8Array {
   // Convert name
+  const query = new URLSearchParams(header).toString();
+  if (query) {
+    name += '?' + query;
+  }
   const encoder = new TextEncoder();
   const nameAsBytes = encoder.encode(name);
 
@@ -174,38 +870,48 @@ function encode(id: number, name: string, data: Uint8Array): Uint8Array {
 }
 
 /**
- * Decodes an RPC or callback response from the binary representation
+ * Decodes an RPC or callback response from the binary representation.
+ * The name may be followed by a header as url encoded query, responses have an empty name.
  */
-function decode(data: ArrayBuffer): ResponseContainer {
+function decode(data: Uint8Array): ResponseContainer {
   let name = "";
+  let nameLength = 0;
   // Find first 0 or FF
-  let arr = new Uint8Array(data);
-  for (let i = 0; i < arr.length; ++i) {
-    if (arr[i] == 0 || arr[i] == 255) {
-      const nameSlice = data.slice(0, i);
+  for (let i = 0; i < data.length; ++i) {
+    if (data[i] == 0 || data[i] == 255) {
+      const nameSlice = data.subarray(0, i);
       name = new TextDecoder().decode(nameSlice);
+      nameLength = i;
       break;
     }
   }
 
+  let header: Metadata = {};
+  const queryStart = name.indexOf('?');
+  if (queryStart >= 0) {
+    header = Object.fromEntries(new URLSearchParams(name.slice(queryStart + 1)));
+    name = name.slice(0, queryStart);
+  }
+
   let id = 0;
   let dataOffset = 1;
   if (name === "") {
-    id = new DataView(data, name.length, name.length + 4).getInt32(0);
+    id = new DataView(data.buffer, data.byteOffset + nameLength, 4).getInt32(0);
     dataOffset = 4;
   }
 
   return {
     id,
     name,
-    data: new Uint8Array(data.slice(name.length + dataOffset)),
+    header,
+    data: data.slice(nameLength + dataOffset),
   };
 }
 `)
 	return sb.String()
 }
 
-func generateRpcServices(pb *unordered.Proto, dto dtoCollectorType) string {
+func generateRpcServices(pb *unordered.Proto, dto dtoCollectorType, runtime TsRuntime) string {
 	var sb strings.Builder
 	w := func(s string) { sb.WriteString(s) }
 	wn := func(s string) { sb.WriteString(s + "\n") }
@@ -224,9 +930,9 @@ func generateRpcServices(pb *unordered.Proto, dto dtoCollectorType) string {
 			dto[rpc.RPCResponse.MessageType] = struct{}{}
 			w("  public async " + firstCharToLower(rpc.RPCName) + "(")
 			if rpc.RPCRequest.MessageType != voidTypeName {
-				w("prm: " + rpc.RPCRequest.MessageType)
+				w("prm: " + rpc.RPCRequest.MessageType + ", ")
 			}
-			w(")")
+			w("options?: CallOptions)")
 
 			if rpc.RPCResponse.MessageType != voidTypeName {
 				wn(": Promise<" + rpc.RPCResponse.MessageType + "> {")
@@ -235,17 +941,17 @@ func generateRpcServices(pb *unordered.Proto, dto dtoCollectorType) string {
 			}
 
 			if rpc.RPCRequest.MessageType != voidTypeName {
-				wn("    const data = " + rpc.RPCRequest.MessageType + ".encode(prm).finish();")
+				wn("    const data = " + runtime.encode(rpc.RPCRequest.MessageType, "prm") + ";")
 			} else {
 				wn("    const data = new Uint8Array([]);")
 			}
 
 			if rpc.RPCResponse.MessageType != voidTypeName {
-				wn("    const responseData = await this.server.rpc('" + rpc.RPCName + "', data);")
-				wn("    const responseObj = " + rpc.RPCResponse.MessageType + ".decode(responseData);")
+				wn("    const responseData = await this.server.rpc('" + rpc.RPCName + "', data, options);")
+				wn("    const responseObj = " + runtime.decode(rpc.RPCResponse.MessageType, "responseData") + ";")
 				wn("    return responseObj;")
 			} else {
-				wn("    await this.server.rpc('" + rpc.RPCName + "', data);")
+				wn("    await this.server.rpc('" + rpc.RPCName + "', data, options);")
 			}
 			wn("  }\n")
 		}
@@ -254,9 +960,8 @@ func generateRpcServices(pb *unordered.Proto, dto dtoCollectorType) string {
 	return sb.String()
 }
 
-func generateSspServices(pb *unordered.Proto, dto dtoCollectorType) string {
+func generateSspServices(pb *unordered.Proto, dto dtoCollectorType, runtime TsRuntime) string {
 	var sb strings.Builder
-	w := func(s string) { sb.WriteString(s) }
 	wn := func(s string) { sb.WriteString(s + "\n") }
 
 	wn("")
@@ -266,26 +971,46 @@ func generateSspServices(pb *unordered.Proto, dto dtoCollectorType) string {
 		}
 
 		wn("export class " + srv.ServiceName + "Impl {")
-		wn("  private readonly callbackListeners: { [key: string]: ((data: unknown) => void)[] } = {};\n")
+		wn("  private readonly callbackListeners: { [key: string]: PushListener[] } = {};\n")
 		wn("  constructor(server: Server) {")
 		for _, rpc := range srv.ServiceBody.RPCs {
-			wn("    server.registerCallbackHandler('" + rpc.RPCName + "', this." + firstCharToLower(rpc.RPCName) + ".bind(this));")
+			wn("    server.registerCallbackHandler('" + rpc.RPCName + "', this.handle" + firstCharToUpper(rpc.RPCName) + ".bind(this));")
 		}
 		wn("  }\n")
 
-		wn(`  private registerCallbackHandler(name: string, cb: (data: unknown) => void) {
+		wn(`  private registerCallbackHandler(name: string, cb: (data: unknown) => void, once: boolean): () => void {
     let listeners = this.callbackListeners[name];
     if (!listeners) {
       listeners = [];
       this.callbackListeners[name] = listeners;
     }
-    listeners.push(cb as (data: unknown) => void);
+    const listener = { cb, once };
+    listeners.push(listener);
+    return () => this.removeListener(name, (l) => l === listener);
+  }
+
+  private removeListener(name: string, match: (l: PushListener) => boolean) {
+    const listeners = this.callbackListeners[name];
+    const i = listeners ? listeners.findIndex(match) : -1;
+    if (i >= 0) {
+      listeners.splice(i, 1);
+    }
+  }
+
+  /** Removes all listeners of a push message, or of all push messages */
+  removeAllListeners(name?: string) {
+    for (const key of Object.keys(this.callbackListeners)) {
+      if (name === undefined || key === name) {
+        this.callbackListeners[key] = [];
+      }
+    }
   }
 
   private callback(name: string, data: unknown) {
     const listeners = this.callbackListeners[name];
     if (listeners) {
-      listeners.forEach((cb) => cb(data));
+      this.callbackListeners[name] = listeners.filter((l) => !l.once);
+      listeners.forEach((l) => l.cb(data));
     } else {
       console.error("No listener for: ", name);
     }
@@ -296,16 +1021,29 @@ func generateSspServices(pb *unordered.Proto, dto dtoCollectorType) string {
 			dto[rpc.RPCRequest.MessageType] = struct{}{}
 			dto[rpc.RPCResponse.MessageType] = struct{}{}
 
-			w("  public on" + firstCharToUpper(rpc.RPCName) + "(")
+			cb := "cb: () => void"
 			if rpc.RPCRequest.MessageType != voidTypeName {
-				w("cb: (p: " + rpc.RPCRequest.MessageType + ") => void")
+				cb = "cb: (p: " + rpc.RPCRequest.MessageType + ") => void"
 			}
-			wn(") {")
-			wn("    this.registerCallbackHandler('" + rpc.RPCName + "', cb as (data: unknown) => void);")
+			name := firstCharToUpper(rpc.RPCName)
+			wn("  /** Registers a listener and returns a function removing it */")
+			wn("  public on" + name + "(" + cb + "): () => void {")
+			wn("    return this.registerCallbackHandler('" + rpc.RPCName + "', cb as (data: unknown) => void, false);")
+			wn("  }\n")
+			wn("  /** Registers a listener for the next message only and returns a function removing it */")
+			wn("  public once" + name + "(" + cb + "): () => void {")
+			wn("    return this.registerCallbackHandler('" + rpc.RPCName + "', cb as (data: unknown) => void, true);")
+			wn("  }\n")
+			wn("  public off" + name + "(" + cb + ") {")
+			wn("    this.removeListener('" + rpc.RPCName + "', (l) => l.cb === cb);")
+			wn("  }\n")
+			wn("  /** Returns the " + rpc.RPCName + " messages as stream */")
+			wn("  public " + firstCharToLower(rpc.RPCName) + "(): PushStream<" + rpc.RPCRequest.MessageType + "> {")
+			wn("    return new PushStream<" + rpc.RPCRequest.MessageType + ">((cb) => this.registerCallbackHandler('" + rpc.RPCName + "', cb as (data: unknown) => void, false));")
 			wn("  }\n")
 
-			wn("  private " + firstCharToLower(rpc.RPCName) + "(rawData: Uint8Array) {")
-			wn("    const obj = " + rpc.RPCRequest.MessageType + ".decode(rawData);")
+			wn("  private handle" + firstCharToUpper(rpc.RPCName) + "(rawData: Uint8Array) {")
+			wn("    const obj = " + runtime.decode(rpc.RPCRequest.MessageType, "rawData") + ";")
 			wn("    this.callback('" + rpc.RPCName + "', obj);")
 			wn("  }\n")
 
@@ -315,7 +1053,9 @@ func generateSspServices(pb *unordered.Proto, dto dtoCollectorType) string {
 	return sb.String()
 }
 
-func generateImports(pb *unordered.Proto, protoDir string, protoFile string, dto dtoCollectorType) string {
+// generateImports imports the message types of dto from the modules of their proto files.
+// With codec the names needed to encode and decode them are imported as well.
+func generateImports(pb *unordered.Proto, protoDir string, protoFile string, dto dtoCollectorType, runtime TsRuntime, codec bool) string {
 	var sb strings.Builder
 
 	if strings.HasPrefix(protoFile, protoDir) {
@@ -368,7 +1108,11 @@ func generateImports(pb *unordered.Proto, protoDir string, protoFile string, dto
 
 	for file, types := range fileImports {
 		slices.Sort(types)
-		w("import { " + strings.Join(types, ", ") + " } from './" + file + "';")
+		var names []string
+		for _, typ := range types {
+			names = append(names, runtime.importNames(typ, codec)...)
+		}
+		w("import { " + strings.Join(names, ", ") + " } from './" + runtime.importFile(file) + "';")
 	}
 	return sb.String()
 }
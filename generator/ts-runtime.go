@@ -0,0 +1,79 @@
+package generator
+
+import (
+	"fmt"
+)
+
+// TsRuntime is the protobuf library the generated TypeScript code encodes and decodes the messages with
+type TsRuntime string
+
+const (
+	// TsRuntimeProtobufJs uses the static code of protobufjs (pbjs -t static-module)
+	TsRuntimeProtobufJs TsRuntime = "protobufjs"
+	// TsRuntimeTsProto uses the code generated by ts-proto
+	TsRuntimeTsProto TsRuntime = "ts-proto"
+	// TsRuntimeProtobufEs uses the code generated by @bufbuild/protoc-gen-es v2
+	TsRuntimeProtobufEs TsRuntime = "protobuf-es"
+)
+
+func ParseTsRuntime(value string) (TsRuntime, error) {
+	switch runtime := TsRuntime(value); runtime {
+	case TsRuntimeProtobufJs, TsRuntimeTsProto, TsRuntimeProtobufEs:
+		return runtime, nil
+	}
+	return "", fmt.Errorf("unknown TypeScript runtime '%s', expected protobufjs, ts-proto or protobuf-es", value)
+}
+
+// encode returns the expression encoding the message in expr to a Uint8Array
+func (r TsRuntime) encode(typ string, expr string) string {
+	if r == TsRuntimeProtobufEs {
+		return "toBinary(" + typ + "Schema, " + expr + ")"
+	}
+	return typ + ".encode(" + expr + ").finish()"
+}
+
+// decode returns the expression decoding the Uint8Array in expr to a message
+func (r TsRuntime) decode(typ string, expr string) string {
+	if r == TsRuntimeProtobufEs {
+		return "fromBinary(" + typ + "Schema, " + expr + ")"
+	}
+	return typ + ".decode(" + expr + ")"
+}
+
+// create returns the expression creating a message from the object literal in init
+func (r TsRuntime) create(typ string, init string) string {
+	if r == TsRuntimeProtobufEs {
+		return "create(" + typ + "Schema, " + init + ")"
+	}
+	return init
+}
+
+// runtimeImport returns the import of the library functions needed by encode and decode, and by create if withCreate is set
+func (r TsRuntime) runtimeImport(withCreate bool) string {
+	if r != TsRuntimeProtobufEs {
+		return ""
+	}
+	if withCreate {
+		return "import { create, fromBinary, toBinary } from '@bufbuild/protobuf';\n"
+	}
+	return "import { fromBinary, toBinary } from '@bufbuild/protobuf';\n"
+}
+
+// importFile returns the module generated for a proto file without the .proto extension
+func (r TsRuntime) importFile(fileNameBase string) string {
+	if r == TsRuntimeProtobufEs {
+		return fileNameBase + "_pb"
+	}
+	return fileNameBase
+}
+
+// importNames returns the names to import for a message type. With codec the names needed to encode and decode it are included.
+func (r TsRuntime) importNames(typ string, codec bool) []string {
+	if r != TsRuntimeProtobufEs {
+		return []string{typ}
+	}
+	if codec {
+		return []string{"type " + typ, typ + "Schema"}
+	}
+	return []string{"type " + typ}
+}
@@ -0,0 +1,569 @@
+package generator
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/yoheimuta/go-protoparser/v4"
+	"github.com/yoheimuta/go-protoparser/v4/interpret/unordered"
+	"github.com/yoheimuta/go-protoparser/v4/parser"
+)
+
+// GenerateTypeScriptServer generates a Node.js server for the rpc services, speaking the protocol of the Go server
+func GenerateTypeScriptServer(pbuf *parser.Proto, protoDir string, protoFile string, tsBaseDir string, runtime TsRuntime) error {
+	pb, err := protoparser.UnorderedInterpret(pbuf)
+	if err != nil {
+		return err
+	}
+
+	code, err := generateTypeScriptServer(pb, protoDir, protoFile, runtime)
+	if err != nil {
+		return err
+	}
+
+	filename := fmt.Sprintf("%s/%s.ts", tsBaseDir, "rpc-server_gen")
+	err = writeFile(filename, code)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func generateTypeScriptServer(pb *unordered.Proto, protoDir string, protoFile string, runtime TsRuntime) (string, error) {
+	var sb strings.Builder
+	w := func(s string) { sb.WriteString(s + "\n") }
+	dto := make(dtoCollectorType)
+	dto[errorTypeName] = struct{}{}
+
+	w("/** Identifies the services and methods the code was generated from */")
+	w("export const SCHEMA_FINGERPRINT = '" + schemaFingerprint(pb) + "';")
+	w(`
+/** Key/value pairs sent along with a request or a response */
+export type Metadata = Record<string, string>;
+
+/** Status code of a response, sent as 'code' metadata of an error */
+export type Code =
+  | 'ok'
+  | 'canceled'
+  | 'unknown'
+  | 'invalid_argument'
+  | 'deadline_exceeded'
+  | 'not_found'
+  | 'permission_denied'
+  | 'resource_exhausted'
+  | 'unimplemented'
+  | 'internal'
+  | 'unavailable'
+  | 'unauthenticated'
+  | 'failed_precondition';
+
+/** Error with a status code. Thrown by a handler it is sent to the client with its details as metadata. */
+export class StatusError extends globalThis.Error {
+  readonly code: Code;
+  readonly details: Metadata;
+
+  constructor(code: Code, message: string, details: Metadata = {}) {
+    super(message);
+    this.name = 'StatusError';
+    this.code = code;
+    this.details = details;
+  }
+}
+
+/** Converts any error to a StatusError, errors without a code get the fallback code */
+function toStatusError(err: unknown, fallback: Code = 'unknown'): StatusError {
+  if (err instanceof StatusError) {
+    return err;
+  }
+  return new StatusError(fallback, err instanceof globalThis.Error ? err.message : String(err));
+}
+
+/** Data of a message as delivered by the socket, e.g. a Buffer of the ws package */
+export type SocketData = Uint8Array | ArrayBuffer | Uint8Array[];
+
+/** The part of a WebSocket used by the server. A WebSocket of the ws package implements it. */
+export interface Socket {
+  send(data: Uint8Array): void;
+  close(): void;
+  on(event: 'message', listener: (data: SocketData) => void): unknown;
+  on(event: 'close', listener: () => void): unknown;
+}
+
+/** Describes a method with the options of the proto file */
+export type MethodDescriptor = {
+  service: string;
+  method: string;
+  auth?: string;
+  public?: boolean;
+  timeoutMs?: number;
+  options: Record<string, string>;
+};
+
+/** The context of a request passed to the handler */
+export type RequestContext = {
+  connection: Connection;
+  method: MethodDescriptor;
+  /** Metadata sent by the client */
+  metadata: Metadata;
+  /** Aborted when the client cancels the call, the deadline is exceeded or the connection closes */
+  signal: AbortSignal;
+  /** Adds metadata to the response */
+  setResponseMetadata(key: string, value: string): void;
+};
+`)
+
+	// Handler interfaces and method descriptors
+	var handlers []string
+	for _, srv := range pb.ProtoBody.Services {
+		if !hasServiceOption(srv, "(is_rpc)") {
+			continue
+		}
+		handlers = append(handlers, "  "+firstCharToLower(srv.ServiceName)+"?: "+srv.ServiceName+"Handler;")
+
+		w("export interface " + srv.ServiceName + "Handler {")
+		for _, rpc := range srv.ServiceBody.RPCs {
+			for _, c := range rpc.Comments {
+				w("  " + c.Raw)
+			}
+			resp := "void"
+			if rpc.RPCResponse.MessageType != voidTypeName {
+				resp = rpc.RPCResponse.MessageType
+				dto[resp] = struct{}{}
+			}
+			if rpc.RPCRequest.MessageType != voidTypeName {
+				dto[rpc.RPCRequest.MessageType] = struct{}{}
+				w("  " + firstCharToLower(rpc.RPCName) + "(prm: " + rpc.RPCRequest.MessageType + ", ctx: RequestContext): Promise<" + resp + "> | " + resp + ";")
+			} else {
+				w("  " + firstCharToLower(rpc.RPCName) + "(ctx: RequestContext): Promise<" + resp + "> | " + resp + ";")
+			}
+		}
+		w("}\n")
+
+		descriptors, err := generateTsMethodDescriptors(srv)
+		if err != nil {
+			return "", err
+		}
+		w(descriptors)
+	}
+
+	w("/** The handlers of the rpc services, services without handler respond with 'unimplemented' */")
+	w("export type Handlers = {")
+	for _, h := range handlers {
+		w(h)
+	}
+	w("};\n")
+
+	w("/** Returns the called method, a name shared by several services belongs to the first of them with a handler */")
+	w("function findMethod(handlers: Handlers, name: string): MethodDescriptor | undefined {")
+	if len(handlers) > 0 {
+		w("  let method: MethodDescriptor | undefined;")
+		for _, srv := range pb.ProtoBody.Services {
+			if !hasServiceOption(srv, "(is_rpc)") {
+				continue
+			}
+			w("  if (Object.prototype.hasOwnProperty.call(" + srv.ServiceName + "Methods, name)) {")
+			w("    if (handlers." + firstCharToLower(srv.ServiceName) + ") {")
+			w("      return " + srv.ServiceName + "Methods[name];")
+			w("    }")
+			w("    method ??= " + srv.ServiceName + "Methods[name];")
+			w("  }")
+		}
+		w("  return method;")
+	} else {
+		w("  return undefined;")
+	}
+	w("}\n")
+
+	// Decoding the parameter, calling the handler and encoding the response
+	w("/** Decodes the parameter, calls the handler and encodes the response */")
+	w("async function callHandler(handlers: Handlers, method: MethodDescriptor, data: Uint8Array, ctx: RequestContext): Promise<Uint8Array> {")
+	w("  switch (method.service + '.' + method.method) {")
+	for _, srv := range pb.ProtoBody.Services {
+		if !hasServiceOption(srv, "(is_rpc)") {
+			continue
+		}
+		for _, rpc := range srv.ServiceBody.RPCs {
+			w("    case '" + srv.ServiceName + "." + rpc.RPCName + "': {")
+			w("      const handler = handlers." + firstCharToLower(srv.ServiceName) + ";")
+			w("      if (!handler) {")
+			w("        throw new StatusError('unimplemented', \"service '" + srv.ServiceName + "' not available\");")
+			w("      }")
+			args := "ctx"
+			if rpc.RPCRequest.MessageType != voidTypeName {
+				w("      let prm: " + rpc.RPCRequest.MessageType + ";")
+				w("      try {")
+				w("        prm = " + runtime.decode(rpc.RPCRequest.MessageType, "data") + ";")
+				w("      } catch (err) {")
+				w("        throw new StatusError('invalid_argument', 'invalid parameter: ' + toStatusError(err).message);")
+				w("      }")
+				args = "prm, ctx"
+			}
+			if rpc.RPCResponse.MessageType != voidTypeName {
+				w("      const resp = await handler." + firstCharToLower(rpc.RPCName) + "(" + args + ");")
+				w("      return " + runtime.encode(rpc.RPCResponse.MessageType, "resp") + ";")
+			} else {
+				w("      await handler." + firstCharToLower(rpc.RPCName) + "(" + args + ");")
+				w("      return new Uint8Array([]);")
+			}
+			w("    }")
+		}
+	}
+	w(`    default:
+      throw new StatusError('unimplemented', 'invalid rpc call: ' + method.method);
+  }
+}
+`)
+
+	w(`export type ConnectionOptions = {
+  /** Validates the credentials sent with the handshake and returns the identity of the client. Requests need an authenticated connection then, and push messages are dropped until it is. */
+  authenticate?: (metadata: Metadata, connection: Connection) => Promise<string> | string;
+  /** Decides if a method may be called, public methods are always allowed */
+  authorize?: (ctx: RequestContext) => Promise<void> | void;
+  /** Called when the client was generated from a different schema. Throwing rejects the handshake. */
+  onSchemaMismatch?: (clientFingerprint: string, connection: Connection) => Promise<void> | void;
+  /** Called with the errors of failed requests */
+  onError?: (err: StatusError, method: string) => void;
+};
+
+/** Serves the rpc services and sends the push messages on a socket */
+export class Connection {
+  private readonly requests = new Map<number, AbortController>();
+  private handshaking: Promise<void> = Promise.resolve();
+  private authenticated = false;
+  private identityValue = '';
+  private closed = false;`)
+	for _, srv := range pb.ProtoBody.Services {
+		if hasServiceOption(srv, "(is_ssp)") {
+			w("  readonly " + firstCharToLower(srv.ServiceName) + ": " + srv.ServiceName + "Sender;")
+		}
+	}
+	w(`
+  constructor(
+    private readonly socket: Socket,
+    private readonly handlers: Handlers,
+    private readonly options: ConnectionOptions = {},
+  ) {`)
+	for _, srv := range pb.ProtoBody.Services {
+		if hasServiceOption(srv, "(is_ssp)") {
+			w("    this." + firstCharToLower(srv.ServiceName) + " = new " + srv.ServiceName + "Sender(this);")
+		}
+	}
+	w(`    socket.on('message', (data) => this.dispatch(toBytes(data)));
+    socket.on('close', () => this.dispose());
+  }
+
+  /** The identity returned by authenticate, undefined until the connection is authenticated */
+  get identity(): string | undefined {
+    return this.authenticated ? this.identityValue : undefined;
+  }
+
+  /** Closes the socket, running requests are canceled */
+  close() {
+    this.socket.close();
+    this.dispose();
+  }
+
+  private dispose() {
+    if (this.closed) {
+      return;
+    }
+    this.closed = true;
+    this.requests.forEach((controller) => controller.abort(new StatusError('canceled', 'connection closed')));
+    this.requests.clear();
+  }
+
+  /** Sends an encoded push message, it is dropped while the connection is not authenticated */
+  sendPush(name: string, data: Uint8Array) {
+    if (this.options.authenticate && !this.authenticated) {
+      console.warn("Dropping push message '" + name + "' before authentication");
+      return;
+    }
+    this.send(encodePush(name, data));
+  }
+
+  private send(frame: Uint8Array) {
+    if (this.closed) {
+      return;
+    }
+    try {
+      this.socket.send(frame);
+    } catch (err) {
+      console.error('Failed to send: ', err);
+    }
+  }
+
+  private sendError(id: number, err: StatusError, metadata: Metadata) {
+    const header = { ...metadata, ...err.details, code: err.code };
+    const data = ` + runtime.encode(errorTypeName, runtime.create(errorTypeName, "{ Error: err.message }")) + `;
+    this.send(encodeResponse(-id, header, data));
+  }
+
+  private dispatch(frame: Uint8Array) {
+    let request: RequestFrame;
+    try {
+      request = decodeRequest(frame);
+    } catch (err) {
+      console.error('Dropping message: ', err);
+      return;
+    }
+
+    switch (request.name) {
+      case '$hello':
+        this.handshaking = this.handshake(request);
+        return;
+      case '$cancel':
+        this.requests.get(request.id)?.abort(new StatusError('canceled', 'canceled by the client'));
+        return;
+    }
+
+    const method = findMethod(this.handlers, request.name);
+    if (!method) {
+      console.error('Invalid rpc call: "' + request.name + '"');
+      this.sendError(request.id, new StatusError('unimplemented', 'invalid rpc call: ' + request.name), {});
+      return;
+    }
+    this.handle(request, method);
+  }
+
+  /** Validates the credentials and binds the identity to the connection. A failed handshake removes a previously bound identity. */
+  private async handshake(request: RequestFrame) {
+    const metadata: Metadata = { schema: SCHEMA_FINGERPRINT };
+    try {
+      const clientFingerprint = request.header.schema;
+      if (clientFingerprint && clientFingerprint !== SCHEMA_FINGERPRINT) {
+        if (!this.options.onSchemaMismatch) {
+          console.warn("Client schema '" + clientFingerprint + "' differs from server schema '" + SCHEMA_FINGERPRINT + "'");
+        } else {
+          try {
+            await this.options.onSchemaMismatch(clientFingerprint, this);
+          } catch (err) {
+            throw toStatusError(err, 'failed_precondition');
+          }
+        }
+      }
+      let identity = '';
+      if (this.options.authenticate) {
+        try {
+          identity = await this.options.authenticate(request.header, this);
+        } catch (err) {
+          throw toStatusError(err, 'unauthenticated');
+        }
+      }
+      this.identityValue = identity;
+      this.authenticated = true;
+      this.send(encodeResponse(request.id, metadata, new Uint8Array([])));
+    } catch (err) {
+      this.identityValue = '';
+      this.authenticated = false;
+      this.sendError(request.id, toStatusError(err), metadata);
+    }
+  }
+
+  /** Checks if the method may be called on this connection */
+  private async authorize(ctx: RequestContext) {
+    if (this.options.authenticate && !this.authenticated) {
+      throw new StatusError('unauthenticated', 'connection not authenticated');
+    }
+    if (ctx.method.public) {
+      return;
+    }
+    if (!this.options.authorize) {
+      if (ctx.method.auth) {
+        throw new StatusError('permission_denied', "no authorizer for '" + ctx.method.method + "'");
+      }
+      return;
+    }
+    try {
+      await this.options.authorize(ctx);
+    } catch (err) {
+      throw toStatusError(err, 'permission_denied');
+    }
+  }
+
+  private async handle(request: RequestFrame, method: MethodDescriptor) {
+    // the controller is registered at once, so a cancel frame can reach a request waiting for the handshake
+    const controller = new AbortController();
+    this.requests.set(request.id, controller);
+    // the deadline starts when the request is received
+    const timeoutMs = Number(request.header['timeout-ms']) || method.timeoutMs;
+    const timer = timeoutMs
+      ? setTimeout(() => controller.abort(new StatusError('deadline_exceeded', "deadline of '" + request.name + "' exceeded")), timeoutMs)
+      : undefined;
+    const responseMetadata: Metadata = {};
+    const ctx: RequestContext = {
+      connection: this,
+      method,
+      metadata: request.header,
+      signal: controller.signal,
+      setResponseMetadata: (key, value) => {
+        responseMetadata[key] = value;
+      },
+    };
+
+    try {
+      const data = await abortable(controller.signal, async () => {
+        await this.handshaking;
+        await this.authorize(ctx);
+        return callHandler(this.handlers, method, request.data, ctx);
+      });
+      this.send(encodeResponse(request.id, responseMetadata, data));
+    } catch (err) {
+      const statusErr = toStatusError(err);
+      this.options.onError?.(statusErr, request.name);
+      this.sendError(request.id, statusErr, responseMetadata);
+    } finally {
+      clearTimeout(timer);
+      if (this.requests.get(request.id) === controller) {
+        this.requests.delete(request.id);
+      }
+    }
+  }
+}
+
+/** Runs fn and rejects with the reason of the signal as soon as it is aborted */
+function abortable<T>(signal: AbortSignal, fn: () => Promise<T>): Promise<T> {
+  return new Promise<T>((resolve, reject) => {
+    if (signal.aborted) {
+      reject(signal.reason);
+      return;
+    }
+    const onAbort = () => reject(signal.reason);
+    signal.addEventListener('abort', onAbort, { once: true });
+    fn().then(resolve, reject).finally(() => signal.removeEventListener('abort', onAbort));
+  });
+}
+`)
+
+	// Push senders
+	for _, srv := range pb.ProtoBody.Services {
+		if !hasServiceOption(srv, "(is_ssp)") {
+			continue
+		}
+		w("/** Sends the push messages of " + srv.ServiceName + " to a connection */")
+		w("export class " + srv.ServiceName + "Sender {")
+		w("  constructor(private readonly connection: Connection) {}")
+		for _, rpc := range srv.ServiceBody.RPCs {
+			w("")
+			for _, c := range rpc.Comments {
+				w("  " + c.Raw)
+			}
+			if rpc.RPCRequest.MessageType != voidTypeName {
+				dto[rpc.RPCRequest.MessageType] = struct{}{}
+				w("  " + firstCharToLower(rpc.RPCName) + "(p: " + rpc.RPCRequest.MessageType + ") {")
+				w("    this.connection.sendPush('" + rpc.RPCName + "', " + runtime.encode(rpc.RPCRequest.MessageType, "p") + ");")
+			} else {
+				w("  " + firstCharToLower(rpc.RPCName) + "() {")
+				w("    this.connection.sendPush('" + rpc.RPCName + "', new Uint8Array([]));")
+			}
+			w("  }")
+		}
+		w("}\n")
+	}
+
+	w(`type RequestFrame = {
+  name: string;
+  id: number;
+  header: Metadata;
+  data: Uint8Array;
+};
+
+function toBytes(data: SocketData): Uint8Array {
+  if (Array.isArray(data)) {
+    const result = new Uint8Array(data.reduce((len, chunk) => len + chunk.length, 0));
+    let offset = 0;
+    for (const chunk of data) {
+      result.set(chunk, offset);
+      offset += chunk.length;
+    }
+    return result;
+  }
+  return data instanceof Uint8Array ? data : new Uint8Array(data);
+}
+
+/**
+ * Decodes an rpc request from the binary representation.
+ * The name may be followed by a header as url encoded query and is terminated by the first byte of the id.
+ */
+function decodeRequest(frame: Uint8Array): RequestFrame {
+  const nameLength = frame.indexOf(0);
+  if (nameLength <= 0 || frame.length < nameLength + 4) {
+    throw new globalThis.Error('invalid rpc frame (' + frame.length + ' bytes)');
+  }
+  let name = new TextDecoder().decode(frame.subarray(0, nameLength));
+  let header: Metadata = {};
+  const queryStart = name.indexOf('?');
+  if (queryStart >= 0) {
+    header = Object.fromEntries(new URLSearchParams(name.slice(queryStart + 1)));
+    name = name.slice(0, queryStart);
+  }
+  const id = new DataView(frame.buffer, frame.byteOffset + nameLength, 4).getInt32(0);
+  return { name, id, header, data: frame.subarray(nameLength + 4) };
+}
+
+/**
+ * Encodes an rpc response to the binary representation.
+ * The header is prepended as url encoded query, negative ids mark errors.
+ */
+function encodeResponse(id: number, header: Metadata, data: Uint8Array): Uint8Array {
+  const query = new URLSearchParams(header).toString();
+  const queryAsBytes = new TextEncoder().encode(query ? '?' + query : '');
+  const result = new Uint8Array(queryAsBytes.length + 4 + data.length);
+  result.set(queryAsBytes, 0);
+  new DataView(result.buffer).setInt32(queryAsBytes.length, id, false);
+  result.set(data, queryAsBytes.length + 4);
+  return result;
+}
+
+/** Encodes a push message, the name is terminated by 0 */
+function encodePush(name: string, data: Uint8Array): Uint8Array {
+  const nameAsBytes = new TextEncoder().encode(name);
+  const result = new Uint8Array(nameAsBytes.length + 1 + data.length);
+  result.set(nameAsBytes, 0);
+  result.set(data, nameAsBytes.length + 1);
+  return result;
+}`)
+
+	imports := runtime.runtimeImport(true) + generateImports(pb, protoDir, protoFile, dto, runtime, true)
+	return imports + generatorWarning + sb.String(), nil
+}
+
+// generateTsMethodDescriptors writes a MethodDescriptor per rpc with the options of the proto file
+func generateTsMethodDescriptors(srv *unordered.Service) (string, error) {
+	var sb strings.Builder
+	w := func(s string) { sb.WriteString(s + "\n") }
+
+	w("/** Describes all methods of " + srv.ServiceName + " by name */")
+	w("export const " + srv.ServiceName + "Methods: Record<string, MethodDescriptor> = {")
+	for _, rpc := range srv.ServiceBody.RPCs {
+		options, names := rpcOptions(rpc)
+		w("  " + rpc.RPCName + ": {")
+		w("    service: " + tsQuote(srv.ServiceName) + ",")
+		w("    method: " + tsQuote(rpc.RPCName) + ",")
+		if auth, exists := options["auth"]; exists {
+			w("    auth: " + tsQuote(auth) + ",")
+		}
+		if options["public"] == "true" {
+			w("    public: true,")
+		}
+		d, err := rpcTimeout(rpc.RPCName, options)
+		if err != nil {
+			return "", err
+		}
+		if d > 0 {
+			w(fmt.Sprintf("    timeoutMs: %d, // %s", d.Milliseconds(), d))
+		}
+		w("    options: {")
+		for _, name := range names {
+			w("      " + tsQuote(name) + ": " + tsQuote(options[name]) + ",")
+		}
+		w("    },")
+		w("  },")
+	}
+	w("};")
+	return sb.String(), nil
+}
+
+// tsQuote returns s as single quoted TypeScript string
+func tsQuote(s string) string {
+	return "'" + strings.NewReplacer("\\", "\\\\", "'", "\\'", "\n", "\\n").Replace(s) + "'"
+}
@@ -2,7 +2,6 @@ module github.com/avirillion/GoWsProtoServiceBuilder
 
 go 1.22.5
 
-require (
-	github.com/yoheimuta/go-protoparser/v4 v4.11.0 // indirect
-	google.golang.org/protobuf v1.34.2 // indirect
-)
+require github.com/yoheimuta/go-protoparser/v4 v4.11.0
+
+require google.golang.org/protobuf v1.34.2 // indirect
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"log"
 	"os"
@@ -12,15 +13,25 @@ import (
 )
 
 func main() {
-	if len(os.Args) != 6 {
+	tsReact := flag.Bool("ts_react", false, "generate React hooks for the TypeScript client")
+	tsServer := flag.Bool("ts_server", false, "generate a Node.js server for the rpc services")
+	tsRuntime := flag.String("ts_runtime", string(servicebuilder.TsRuntimeProtobufJs), "protobuf library of the TypeScript client: protobufjs, ts-proto or protobuf-es")
+	flag.Usage = printHelp
+	flag.Parse()
+	if flag.NArg() != 5 {
 		printHelp()
 		os.Exit(1)
 	}
-	protoBufPath := os.Args[1]
-	protoBufFile := os.Args[2]
-	goBaseDir := os.Args[3]
-	goPackage := os.Args[4]
-	tsBaseDir := os.Args[5]
+	protoBufPath := flag.Arg(0)
+	protoBufFile := flag.Arg(1)
+	goBaseDir := flag.Arg(2)
+	goPackage := flag.Arg(3)
+	tsBaseDir := flag.Arg(4)
+
+	runtime, err := servicebuilder.ParseTsRuntime(*tsRuntime)
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	pbuf, err := parseProtoBuf(protoBufFile)
 	if err != nil {
@@ -39,15 +50,54 @@ func main() {
 	if err != nil {
 		log.Fatal(err)
 	}
-	err = servicebuilder.GenerateTypeScriptFile(pbuf, protoBufPath, protoBufFile, tsBaseDir)
+	err = servicebuilder.GenerateGoMetrics(goBaseDir, goPackage)
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = servicebuilder.GenerateGoTracing(goBaseDir, goPackage)
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = servicebuilder.GenerateGoRateLimit(goBaseDir, goPackage)
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = servicebuilder.GenerateGoConn(pbuf, goBaseDir, goPackage)
 	if err != nil {
 		log.Fatal(err)
 	}
+	err = servicebuilder.GenerateGoClient(pbuf, goBaseDir, goPackage)
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = servicebuilder.GenerateGoTestKit(goBaseDir, goPackage)
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = servicebuilder.GenerateTypeScriptFile(pbuf, protoBufPath, protoBufFile, tsBaseDir, runtime)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *tsServer {
+		err = servicebuilder.GenerateTypeScriptServer(pbuf, protoBufPath, protoBufFile, tsBaseDir, runtime)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *tsReact {
+		err = servicebuilder.GenerateTypeScriptReactHooks(pbuf, protoBufPath, protoBufFile, tsBaseDir, runtime)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
 }
 
 func printHelp() {
 	fmt.Println(`Usage:
-	service-builder <protobuf-path> <protobuf-file> <go-base-dir> <go-package> <ts-service-dir>`)
+	service-builder [options] <protobuf-path> <protobuf-file> <go-base-dir> <go-package> <ts-service-dir>
+
+Options:`)
+	flag.PrintDefaults()
 }
 
 func parseProtoBuf(file string) (*parser.Proto, error) {